@@ -0,0 +1,152 @@
+package sensorsanalytics
+
+import "context"
+
+// BoundClient 绑定了用户的 Client，发送数据时不需要再传入 distinct_id，
+// 适合在一次请求的处理过程中使用。可以并发使用。
+type BoundClient struct {
+	client     *Client
+	distinctID string
+	isLoginID  bool
+	properties map[string]interface{}
+}
+
+// Identity 从请求中识别出的用户，供 sahttp、sagrpc 等中间件使用
+type Identity struct {
+	DistinctID string
+	IsLoginID  bool
+}
+
+// IdentityFunc 从请求中识别用户，无法识别时返回 false。
+// T 为请求的类型，例如 sahttp 中为 *http.Request，sagrpc 中为 context.Context
+type IdentityFunc[T any] func(req T) (Identity, bool)
+
+// FirstOf 按顺序尝试多个 IdentityFunc，返回第一个识别出的用户，
+// 例如优先使用登录后的用户 ID，其次使用匿名 ID
+func FirstOf[T any](fns ...IdentityFunc[T]) IdentityFunc[T] {
+	return func(req T) (Identity, bool) {
+		for _, fn := range fns {
+			if id, ok := fn(req); ok {
+				return id, true
+			}
+		}
+		return Identity{}, false
+	}
+}
+
+// Bind 返回绑定了用户 distinctID 的 BoundClient
+// :param distinctID: 用户的唯一标识
+// :param isLoginID: distinctID 是否为登录 ID
+func (c *Client) Bind(distinctID string, isLoginID bool) *BoundClient {
+	return &BoundClient{client: c, distinctID: distinctID, isLoginID: isLoginID}
+}
+
+// BindIdentity 返回绑定了用户 id 的 BoundClient
+func (c *Client) BindIdentity(id Identity) *BoundClient {
+	return c.Bind(id.DistinctID, id.IsLoginID)
+}
+
+// Client 返回发送数据的 Client
+func (b *BoundClient) Client() *Client {
+	return b.client
+}
+
+// DistinctID 返回绑定的用户标识
+func (b *BoundClient) DistinctID() string {
+	return b.distinctID
+}
+
+// IsLoginID 绑定的用户标识是否为登录 ID
+func (b *BoundClient) IsLoginID() bool {
+	return b.isLoginID
+}
+
+// With 返回新的 BoundClient，之后 Track 的事件都带有 properties 中的属性，Track 时传入的属性优先
+func (b *BoundClient) With(properties map[string]interface{}) *BoundClient {
+	merged := make(map[string]interface{}, len(b.properties)+len(properties))
+	for k, v := range b.properties {
+		merged[k] = v
+	}
+	for k, v := range properties {
+		merged[k] = v
+	}
+	return &BoundClient{client: b.client, distinctID: b.distinctID, isLoginID: b.isLoginID, properties: merged}
+}
+
+// Track 跟踪绑定用户的行为
+// :param eventName: 事件名称
+// :param properties: 事件的属性
+func (b *BoundClient) Track(eventName string, properties map[string]interface{}) error {
+	if len(b.properties) > 0 {
+		merged := make(map[string]interface{}, len(b.properties)+len(properties))
+		for k, v := range b.properties {
+			merged[k] = v
+		}
+		for k, v := range properties {
+			merged[k] = v
+		}
+		properties = merged
+	}
+	return b.client.Track(b.distinctID, eventName, properties, b.isLoginID)
+}
+
+// ProfileSet 设置绑定用户的 Profile，如果已存在则覆盖
+func (b *BoundClient) ProfileSet(profiles map[string]interface{}) error {
+	return b.client.ProfileSet(b.distinctID, profiles, b.isLoginID)
+}
+
+// ProfileSetOnce 首次设置绑定用户的 Profile，如果已存在则不覆盖
+func (b *BoundClient) ProfileSetOnce(profiles map[string]interface{}) error {
+	return b.client.ProfileSetOnce(b.distinctID, profiles, b.isLoginID)
+}
+
+// ProfileIncrement 增加或减少绑定用户的一个或多个数值类型的 Profile
+func (b *BoundClient) ProfileIncrement(profiles map[string]interface{}) error {
+	return b.client.ProfileIncrement(b.distinctID, profiles, b.isLoginID)
+}
+
+// ProfileAppend 追加绑定用户的一个或多个集合类型的 Profile
+func (b *BoundClient) ProfileAppend(profiles map[string]interface{}) error {
+	return b.client.ProfileAppend(b.distinctID, profiles, b.isLoginID)
+}
+
+// ProfileUnset 删除绑定用户的一个或多个 Profile
+func (b *BoundClient) ProfileUnset(profileKeys []string) error {
+	return b.client.ProfileUnset(b.distinctID, profileKeys, b.isLoginID)
+}
+
+// ProfileDelete 删除绑定用户的整个 Profile
+func (b *BoundClient) ProfileDelete() error {
+	return b.client.ProfileDelete(b.distinctID, b.isLoginID)
+}
+
+type boundClientKey struct{}
+
+// NewContext 返回带有 BoundClient 的 context，供 sahttp、sagrpc 等中间件使用
+func NewContext(ctx context.Context, b *BoundClient) context.Context {
+	return context.WithValue(ctx, boundClientKey{}, b)
+}
+
+// FromContext 返回 context 中的 BoundClient，没有时返回 nil 及 false
+func FromContext(ctx context.Context) (*BoundClient, bool) {
+	b, ok := ctx.Value(boundClientKey{}).(*BoundClient)
+	return b, ok && b != nil
+}
+
+// TrackContext 使用 context 中绑定了用户的 Client 发送事件，没有时返回 ErrNoBoundClient
+func TrackContext(ctx context.Context, eventName string, properties map[string]interface{}) error {
+	b, ok := FromContext(ctx)
+	if !ok {
+		return ErrNoBoundClient
+	}
+	return b.Track(eventName, properties)
+}
+
+// ProfileSetContext 使用 context 中绑定了用户的 Client 设置用户属性，没有时返回 ErrNoBoundClient
+func ProfileSetContext(ctx context.Context, profiles map[string]interface{}) error {
+	b, ok := FromContext(ctx)
+	if !ok {
+		return ErrNoBoundClient
+	}
+	return b.ProfileSet(profiles)
+}
@@ -0,0 +1,182 @@
+package sensorsanalytics
+
+import (
+	"sync"
+	"time"
+)
+
+// BreakerState 熔断器状态
+type BreakerState int
+
+const (
+	// BreakerClosed 正常发送请求
+	BreakerClosed BreakerState = iota
+	// BreakerOpen 不发送请求，直接返回 ErrCircuitOpen
+	BreakerOpen
+	// BreakerHalfOpen 允许少量试探请求通过，成功后关闭，失败后重新打开
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+const (
+	// DefaultBreakerFailureThreshold 默认连续失败多少次后打开熔断器
+	DefaultBreakerFailureThreshold = 5
+	// DefaultBreakerOpenTimeout 默认熔断器打开多久后进入半开状态
+	DefaultBreakerOpenTimeout = 30 * time.Second
+	// DefaultBreakerHalfOpenRequests 默认半开状态下需要连续成功的试探请求数
+	DefaultBreakerHalfOpenRequests = 1
+)
+
+// BreakerConfig 熔断器配置，零值字段使用默认值
+type BreakerConfig struct {
+	// FailureThreshold 连续失败多少次后打开熔断器
+	FailureThreshold int
+	// OpenTimeout 熔断器打开多久后进入半开状态
+	OpenTimeout time.Duration
+	// HalfOpenRequests 半开状态下同时允许的试探请求数，全部成功后关闭熔断器
+	HalfOpenRequests int
+	// OnStateChange 熔断器状态变化时调用
+	OnStateChange func(from BreakerState, to BreakerState)
+}
+
+// CircuitBreaker 服务器不可用时快速失败，避免每次发送都等待一个失败的请求。
+// 只有网络错误及 5xx 错误会被计为失败。
+type CircuitBreaker struct {
+	lock      sync.Mutex
+	config    BreakerConfig
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	probes    int
+	successes int
+	clock     Clock
+}
+
+// NewCircuitBreaker 创建新的熔断器
+func NewCircuitBreaker(config BreakerConfig) *CircuitBreaker {
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = DefaultBreakerFailureThreshold
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = DefaultBreakerOpenTimeout
+	}
+	if config.HalfOpenRequests <= 0 {
+		config.HalfOpenRequests = DefaultBreakerHalfOpenRequests
+	}
+	return &CircuitBreaker{config: config}
+}
+
+// SetClock 设置计算打开时长使用的 Clock，为 nil 时使用系统时间
+func (b *CircuitBreaker) SetClock(clock Clock) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.clock = clock
+}
+
+// now 返回当前时间，调用时需持有锁
+func (b *CircuitBreaker) now() time.Time {
+	if b.clock != nil {
+		return b.clock.Now()
+	}
+	return time.Now()
+}
+
+// State 返回熔断器当前状态
+func (b *CircuitBreaker) State() BreakerState {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.config.OpenTimeout {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// Do 熔断器允许时执行 fn 并记录结果，否则返回 ErrCircuitOpen
+func (b *CircuitBreaker) Do(fn func() error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := fn()
+	b.record(err == nil || !isRetryable(err))
+	return err
+}
+
+func (b *CircuitBreaker) allow() error {
+	b.lock.Lock()
+	from := b.state
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.config.OpenTimeout {
+			b.lock.Unlock()
+			return ErrCircuitOpen
+		}
+		b.setState(BreakerHalfOpen)
+		b.probes = 1
+	case BreakerHalfOpen:
+		if b.probes >= b.config.HalfOpenRequests {
+			b.lock.Unlock()
+			return ErrCircuitOpen
+		}
+		b.probes++
+	}
+	to := b.state
+	b.lock.Unlock()
+	b.notify(from, to)
+	return nil
+}
+
+func (b *CircuitBreaker) record(success bool) {
+	b.lock.Lock()
+	from := b.state
+	switch b.state {
+	case BreakerClosed:
+		if success {
+			b.failures = 0
+		} else {
+			b.failures++
+			if b.failures >= b.config.FailureThreshold {
+				b.setState(BreakerOpen)
+			}
+		}
+	case BreakerHalfOpen:
+		if success {
+			b.successes++
+			if b.successes >= b.config.HalfOpenRequests {
+				b.setState(BreakerClosed)
+			}
+		} else {
+			b.setState(BreakerOpen)
+		}
+	}
+	to := b.state
+	b.lock.Unlock()
+	b.notify(from, to)
+}
+
+// setState 切换状态并重置计数，调用时需持有锁
+func (b *CircuitBreaker) setState(state BreakerState) {
+	b.state = state
+	b.failures = 0
+	b.probes = 0
+	b.successes = 0
+	if state == BreakerOpen {
+		b.openedAt = b.now()
+	}
+}
+
+func (b *CircuitBreaker) notify(from BreakerState, to BreakerState) {
+	if from != to && b.config.OnStateChange != nil {
+		b.config.OnStateChange(from, to)
+	}
+}
@@ -0,0 +1,139 @@
+package sensorsanalytics_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/satest"
+)
+
+var errServer = &sa.StatusError{StatusCode: http.StatusInternalServerError}
+
+// newTestBreaker 创建使用 FakeClock 的熔断器，返回记录状态变化的切片
+func newTestBreaker(config sa.BreakerConfig) (*sa.CircuitBreaker, *satest.FakeClock, *[]string) {
+	var changes []string
+	config.OnStateChange = func(from sa.BreakerState, to sa.BreakerState) {
+		changes = append(changes, fmt.Sprintf("%s->%s", from, to))
+	}
+	breaker := sa.NewCircuitBreaker(config)
+	clock := satest.NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	breaker.SetClock(clock)
+	return breaker, clock, &changes
+}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	breaker, clock, changes := newTestBreaker(sa.BreakerConfig{FailureThreshold: 2, OpenTimeout: time.Minute})
+	fail := func() error { return errServer }
+	ok := func() error { return nil }
+	expectState := func(want sa.BreakerState) {
+		t.Helper()
+		if got := breaker.State(); got != want {
+			t.Fatalf("state %s, want %s", got, want)
+		}
+	}
+
+	// 成功及非服务器错误都会重置连续失败计数
+	breaker.Do(fail)
+	breaker.Do(ok)
+	breaker.Do(fail)
+	breaker.Do(func() error { return &sa.StatusError{StatusCode: http.StatusBadRequest} })
+	breaker.Do(fail)
+	expectState(sa.BreakerClosed)
+
+	breaker.Do(fail)
+	expectState(sa.BreakerOpen)
+	called := false
+	if err := breaker.Do(func() error { called = true; return nil }); err != sa.ErrCircuitOpen || called {
+		t.Fatalf("Do() while open = %v, called %v, want ErrCircuitOpen without calling", err, called)
+	}
+
+	clock.Advance(time.Minute - time.Second)
+	expectState(sa.BreakerOpen)
+	clock.Advance(time.Second)
+	expectState(sa.BreakerHalfOpen)
+
+	// 试探失败后重新打开，并重新计时
+	breaker.Do(fail)
+	expectState(sa.BreakerOpen)
+	clock.Advance(time.Minute)
+	if err := breaker.Do(ok); err != nil {
+		t.Fatal(err)
+	}
+	expectState(sa.BreakerClosed)
+
+	want := []string{"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed"}
+	if !reflect.DeepEqual(*changes, want) {
+		t.Errorf("state changes %v, want %v", *changes, want)
+	}
+}
+
+func TestCircuitBreakerHalfOpenRequests(t *testing.T) {
+	breaker, clock, _ := newTestBreaker(sa.BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenRequests: 2})
+	breaker.Do(func() error { return errServer })
+	clock.Advance(time.Minute)
+
+	// 第一个试探请求完成前，只允许再有一个试探请求
+	var second, third error
+	breaker.Do(func() error {
+		second = breaker.Do(func() error {
+			third = breaker.Do(func() error { return nil })
+			return nil
+		})
+		return nil
+	})
+	if second != nil || third != sa.ErrCircuitOpen {
+		t.Errorf("probes returned %v and %v, want nil and ErrCircuitOpen", second, third)
+	}
+	if got := breaker.State(); got != sa.BreakerClosed {
+		t.Errorf("state %s after all probes succeeded, want closed", got)
+	}
+}
+
+func TestCircuitBreakerFallback(t *testing.T) {
+	collector := satest.NewCollector()
+	defer collector.Close()
+	collector.SetStatus(http.StatusServiceUnavailable)
+	consumer, err := sa.NewDefaultConsumer(collector.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	breaker, clock, _ := newTestBreaker(sa.BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute})
+	consumer.SetCircuitBreaker(breaker)
+
+	// 未设置 fallback 时返回 ErrCircuitOpen
+	var statusErr *sa.StatusError
+	if err := consumer.Send(trackMsg("u1", nil)); !errors.As(err, &statusErr) {
+		t.Fatalf("first Send() = %v, want the server error", err)
+	}
+	if err := consumer.Send(trackMsg("u1", nil)); err != sa.ErrCircuitOpen {
+		t.Fatalf("Send() while open = %v, want ErrCircuitOpen", err)
+	}
+
+	fallback := satest.NewRecordingConsumer()
+	consumer.SetFallback(fallback)
+	if err := consumer.Send(trackMsg("u2", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if envelopes := fallback.Envelopes(); len(envelopes) != 1 || envelopes[0].DistinctID != "u2" {
+		t.Errorf("fallback received %+v, want the message for u2", envelopes)
+	}
+	if got := len(collector.Requests()); got != 1 {
+		t.Errorf("collector received %d requests, want only the first", got)
+	}
+
+	// 服务器恢复后，试探请求成功关闭熔断器，数据不再转交
+	collector.SetStatus(0)
+	clock.Advance(time.Minute)
+	if err := consumer.Send(trackMsg("u3", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if breaker.State() != sa.BreakerClosed || len(collector.Events()) != 1 || len(fallback.Envelopes()) != 1 {
+		t.Errorf("state %s, collector events %d, fallback %d, want closed, 1 and 1",
+			breaker.State(), len(collector.Events()), len(fallback.Envelopes()))
+	}
+}
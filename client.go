@@ -2,54 +2,154 @@ package sensorsanalytics
 
 import (
 	"errors"
-	"fmt"
-	"reflect"
-	"regexp"
-	"strconv"
+	"sync/atomic"
 	"time"
 )
 
 // Client sensoranalytics client
 type Client struct {
 	consumer        Consumer
+	pipeline        Consumer
+	middlewares     []Middleware
 	projectName     *string
 	enableTimeFree  bool
 	appVersion      *string
 	superProperties map[string]interface{}
-	namePattern     *regexp.Regexp
+	redactor        *redactor
+	samplingRules   []SamplingRule
+	sampledOut      int64
+	consentStore    ConsentStore
+	categorize      func(eventType string, eventName string) ConsentCategory
+	suppressed      int64
+	clock           Clock
+	signupStore     SignupStore
+}
+
+// Clock 提供事件的当前时间，测试时可替换为固定的时间
+type Clock interface {
+	Now() time.Time
 }
 
 // NewClient create new client
 func NewClient(consumer Consumer, projectName string, timeFree bool) (*Client, error) {
 	var c Client
 	c.consumer = consumer
+	c.pipeline = consumer
 	if projectName == "" {
 		return &c, errors.New("project_name must not be empty")
 	}
 	c.projectName = &projectName
 	c.enableTimeFree = timeFree
-	namePattern, err := regexp.Compile("^([a-zA-Z_$][a-zA-Z0-9_$]{0,99}$)")
-	if err != nil {
-		return nil, err
-	}
-	c.namePattern = namePattern
+	c.signupStore = NewMemorySignupStore(DefaultSignupStoreCapacity)
 	c.ClearSuperProperties()
 	return &c, nil
 }
 
-func (c *Client) match(input string) bool {
-	if c.namePattern.Match([]byte(input)) {
-		for _, keyword := range FieldKeywords {
-			if keyword == input {
-				return false
-			}
+// Use 添加 Middleware，数据按添加顺序经过每个 Middleware 后交给 Consumer
+func (c *Client) Use(middlewares ...Middleware) {
+	c.middlewares = append(c.middlewares, middlewares...)
+	c.pipeline = Chain(c.consumer, c.middlewares...)
+}
+
+// SetRedactionPolicy 设置敏感信息处理策略，在数据检查前处理事件属性、用户属性及 distinct_id。
+// 规则中有 RedactHash 或 ValuePattern 而 HashKey 为空时返回错误。
+func (c *Client) SetRedactionPolicy(policy RedactionPolicy) error {
+	r, err := newRedactor(policy)
+	if err != nil {
+		return err
+	}
+	c.redactor = r
+	return nil
+}
+
+// RedactionAudit 返回敏感信息处理次数统计
+func (c *Client) RedactionAudit() RedactionAudit {
+	if c.redactor == nil {
+		return RedactionAudit{
+			ByRule:   map[string]int64{},
+			ByAction: map[RedactAction]int64{},
 		}
-		return true
 	}
-	return false
+	return c.redactor.snapshot()
+}
+
+// SetSamplingRules 设置事件采样规则，使用第一个匹配的规则，被采样的事件会带有 $sample_rate 属性
+func (c *Client) SetSamplingRules(rules ...SamplingRule) error {
+	if err := validateSamplingRules(rules); err != nil {
+		return err
+	}
+	c.samplingRules = rules
+	return nil
+}
+
+// SampledOutCount 返回因采样未发送的事件数
+func (c *Client) SampledOutCount() int64 {
+	return atomic.LoadInt64(&c.sampledOut)
+}
+
+// SetConsentStore 设置用户同意记录，每次发送前检查用户是否撤回了对该数据用途的同意，
+// 撤回同意的用户的数据不会被发送，也不会返回错误。
+// :param store: 用户同意记录
+// :param categorize: 返回数据的用途，为 nil 时所有数据都属于 ConsentAnalytics
+func (c *Client) SetConsentStore(store ConsentStore, categorize func(eventType string, eventName string) ConsentCategory) {
+	c.consentStore = store
+	c.categorize = categorize
+}
+
+// SuppressedCount 返回因用户撤回同意而未发送的数据条数
+func (c *Client) SuppressedCount() int64 {
+	return atomic.LoadInt64(&c.suppressed)
+}
+
+// ForgetUser 删除 Consumer 中该用户尚未发送的数据，并发送 ProfileDelete 删除该用户的信息。
+//
+// 执行顺序：先删除尚未发送的数据，再发送 ProfileDelete。Consumer 中缓存的是已经过敏感信息处理的数据，
+// 设置了 RedactionPolicy 时按处理后的 distinct_id (HMAC) 删除，ProfileDelete 同样使用处理后的 distinct_id。
+//
+// ProfileDelete 不检查 ConsentStore：撤回同意的用户正是最需要删除数据的用户，删除请求不能被同意检查拦截。
+// 服务器根据 $is_login_id 区分登录 ID 与匿名 ID，登录 ID 的用户需使用 ForgetLoginUser。
+// :param distinctID: 用户的匿名 ID
+func (c *Client) ForgetUser(distinctID string) error {
+	return c.forgetUser(distinctID, false)
+}
+
+// ForgetLoginUser 与 ForgetUser 相同，用于以登录 ID 标识的用户，ProfileDelete 带有 $is_login_id
+// :param loginID: 用户的登录 ID
+func (c *Client) ForgetLoginUser(loginID string) error {
+	return c.forgetUser(loginID, true)
+}
+
+func (c *Client) forgetUser(distinctID string, isLoginID bool) error {
+	// 缓存中的数据已经过敏感信息处理，按处理后的 distinct_id 删除；只用于查找，不计入统计
+	purgeID := distinctID
+	if c.redactor != nil {
+		purgeID = c.redactor.redactID(distinctID, false)
+	}
+	purge(c.pipeline, purgeID)
+	return c.sendEvent("profile_delete", "", distinctID, "", map[string]interface{}{}, isLoginID)
+}
+
+// optedOut 判断用户是否撤回了对该数据用途的同意
+func (c *Client) optedOut(eventType string, eventName string, distinctID string) (bool, error) {
+	if c.consentStore == nil {
+		return false, nil
+	}
+	category := ConsentAnalytics
+	if c.categorize != nil {
+		category = c.categorize(eventType, eventName)
+	}
+	return c.consentStore.IsOptedOut(distinctID, category)
+}
+
+// SetClock 设置获取当前时间的 Clock，为 nil 时使用系统时间
+func (c *Client) SetClock(clock Clock) {
+	c.clock = clock
 }
 
 func (c *Client) now() int64 {
+	if c.clock != nil {
+		return c.clock.Now().Unix() * 1000
+	}
 	return time.Now().Unix() * 1000
 }
 
@@ -74,120 +174,48 @@ func (c *Client) ClearSuperProperties() {
 // :param eventName: 事件名称
 // :param properties: 事件的属性
 func (c *Client) Track(distinctID string, eventName string, properties map[string]interface{}, isLoginID bool) error {
-	allProperties := c.superProperties
-	if properties != nil {
-		for k, v := range properties {
-			allProperties[k] = v
-		}
+	allProperties := c.mergeSuperProperties(properties)
+	keep, rate, sampled := sample(c.samplingRules, distinctID, eventName, allProperties)
+	if !keep {
+		atomic.AddInt64(&c.sampledOut, 1)
+		return nil
+	}
+	if sampled {
+		allProperties[SampleRateProperty] = rate
 	}
 	return c.trackEvent("track", eventName, distinctID, "", allProperties, isLoginID)
 }
 
+// mergeSuperProperties 返回合并了公共属性的新 map，properties 中的值优先
+func (c *Client) mergeSuperProperties(properties map[string]interface{}) map[string]interface{} {
+	allProperties := make(map[string]interface{}, len(c.superProperties)+len(properties))
+	for k, v := range c.superProperties {
+		allProperties[k] = v
+	}
+	for k, v := range properties {
+		allProperties[k] = v
+	}
+	return allProperties
+}
+
 // TrackSignup 这个接口是一个较为复杂的功能，请在使用前先阅读相关说明:http://www.sensorsdata.cn/manual/track_signup.html，
-// 并在必要时联系我们的技术支持人员。
+// 并在必要时联系我们的技术支持人员。通常使用 Login 即可。
 // :param distinct_id: 用户注册之后的唯一标识
 // :param original_id: 用户注册前的唯一标识
 // :param properties: 事件的属性
 func (c *Client) TrackSignup(distinctID string, originalID string, properties map[string]interface{}) error {
 	if len(originalID) == 0 {
-		return fmt.Errorf("%s: %s", ErrIllegalDataException, "property [original_id] must not be empty")
+		return invalid("original_id", "property [original_id] must not be empty")
 	}
 	if len(originalID) > 255 {
-		return fmt.Errorf("%s: %s", ErrIllegalDataException, "the max length of property [original_id] is 255")
-	}
-	allProperties := c.superProperties
-	if properties != nil {
-		for key, value := range properties {
-			allProperties[key] = value
-		}
+		return invalid("original_id", "the max length of property [original_id] is 255")
 	}
+	allProperties := c.mergeSuperProperties(properties)
 	return c.trackEvent("track_signup", "$SignUp", distinctID, originalID, allProperties, false)
 }
 
 func (c *Client) normalizeData(data map[string]interface{}) (map[string]interface{}, error) {
-	// 检查 distinct_id
-	distinctIDI, ok := data["distinct_id"]
-	if !ok {
-		return data, fmt.Errorf("%s: %s", ErrIllegalDataException, "property [distinct_id] must not be empty")
-	}
-	distinctID, ok := distinctIDI.(string)
-	if !ok || len(distinctID) == 0 {
-		return data, fmt.Errorf("%s: %s", ErrIllegalDataException, "property [distinct_id] must not be empty")
-	}
-	if len(distinctID) > 255 {
-		return data, fmt.Errorf("%s: %s", ErrIllegalDataException, "the max length of [distinct_id] is 255")
-	}
-	// 检查 time
-	tsI, ok := data["time"]
-	if !ok {
-		return data, fmt.Errorf("%s: %s", ErrIllegalDataException, "property [time] must not be empty")
-	}
-	ts, ok := tsI.(int64)
-	if !ok {
-		return data, fmt.Errorf("%s: %s", ErrIllegalDataException, "property [time] must be int64")
-	}
-	tsNum := len(strconv.FormatInt(ts, 10))
-	if tsNum < 10 || tsNum > 13 {
-		return data, fmt.Errorf("%s: %s", ErrIllegalDataException, "property [time] must be a timestamp in microseconds")
-	}
-	if tsNum == 10 {
-		ts *= 1000
-	}
-	data["time"] = ts
-
-	// 检查 event name
-	eventI, ok := data["event"]
-	if ok {
-		event, ok := eventI.(string)
-		if !ok {
-			return data, fmt.Errorf("%s: %s", ErrIllegalDataException, "property [event] must no be empty")
-		}
-		if !c.match(event) {
-			return data, fmt.Errorf("%s: %s", ErrIllegalDataException, fmt.Sprintf("event name must be a valid variable name. [event=%s]", event))
-		}
-	}
-	// 检查 project name
-	projectI, ok := data["project"]
-	if ok {
-		project, ok := projectI.(string)
-		if !ok {
-			return data, fmt.Errorf("%s: %s", ErrIllegalDataException, "property [project] must no be empty")
-		}
-		if !c.match(project) {
-			return data, fmt.Errorf("%s: %s", ErrIllegalDataException, fmt.Sprintf("project name must be a valid variable name. [project=%s]", project))
-		}
-	}
-	// 检查 properties
-	propertiesi, ok := data["properties"]
-	if ok {
-		properties, ok := propertiesi.(map[string]interface{})
-		if ok {
-			for key, value := range properties {
-				if len(key) > 255 {
-					return data, fmt.Errorf("%s: %s", ErrIllegalDataException, fmt.Sprintf("the max length of property key is 256. [key=%s]", key))
-				}
-				if !c.match(key) {
-					return data, fmt.Errorf("%s: %s", ErrIllegalDataException, fmt.Sprintf("the property key must be a valid variable name. [key=%s]", key))
-				}
-				switch value.(type) {
-				case string:
-					v, ok := value.(string)
-					if ok {
-						if len(v) > 8192 {
-							return data, fmt.Errorf("%s: %s", ErrIllegalDataException, fmt.Sprintf("the max length of property value is 8192. [value=%s]", value))
-						}
-					}
-				case int, int32, int64, float32, float64, []string, bool:
-					continue
-				default:
-					return data, fmt.Errorf("%s: %s", ErrIllegalDataException, fmt.Sprintf("default: property value must be a str/int/float/list. [key=%s, value=%s]", key, reflect.TypeOf(value)))
-				}
-			}
-		} else {
-			return data, fmt.Errorf("%s: %s", ErrIllegalDataException, "properties must be a map[string]interface{}")
-		}
-	}
-	return data, nil
+	return normalizeMessage(data)
 }
 
 func (c *Client) getLibProperties() map[string]interface{} {
@@ -275,6 +303,24 @@ func (c *Client) ProfileDelete(distinctID string, isLoginID bool) error {
 }
 
 func (c *Client) trackEvent(eventType string, eventName string, distinctID string, originalID string, properties map[string]interface{}, isLoginID bool) error {
+	optedOut, err := c.optedOut(eventType, eventName, distinctID)
+	if err != nil {
+		return err
+	}
+	if optedOut {
+		atomic.AddInt64(&c.suppressed, 1)
+		return nil
+	}
+	return c.sendEvent(eventType, eventName, distinctID, originalID, properties, isLoginID)
+}
+
+func (c *Client) sendEvent(eventType string, eventName string, distinctID string, originalID string, properties map[string]interface{}, isLoginID bool) error {
+	// 复制一份 properties，避免修改调用方传入的 map
+	copied := make(map[string]interface{}, len(properties)+1)
+	for k, v := range properties {
+		copied[k] = v
+	}
+	properties = copied
 	var eventTime int64
 	t := c.extractUserTime(properties)
 	if t != nil {
@@ -304,19 +350,22 @@ func (c *Client) trackEvent(eventType string, eventName string, distinctID strin
 	if c.enableTimeFree {
 		data["time_free"] = true
 	}
+	if c.redactor != nil {
+		c.redactor.apply(data)
+	}
 	data, err := c.normalizeData(data)
 	if err != nil {
 		return err
 	}
-	return c.consumer.Send(data)
+	return c.pipeline.Send(data)
 }
 
 // Flush 对于不立即发送数据的 Consumer，调用此接口应当立即进行已有数据的发送。
 func (c *Client) Flush() error {
-	return c.consumer.Flush()
+	return c.pipeline.Flush()
 }
 
 // Close 在进程结束或者数据发送完成时，应当调用此接口，以保证所有数据被发送完毕。如果发生意外，此方法将抛出异常。
 func (c *Client) Close() error {
-	return c.consumer.Close()
+	return c.pipeline.Close()
 }
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+// agent 扫描目录中的文件并发送新写入的数据
+type agent struct {
+	config   agentConfig
+	consumer *sa.DefaultConsumer
+	state    *agentState
+}
+
+func newAgent(config agentConfig, consumer *sa.DefaultConsumer) (*agent, error) {
+	state, err := loadState(config.statePath)
+	if err != nil {
+		return nil, err
+	}
+	if config.afterShip == "archive" {
+		if err := os.MkdirAll(config.archiveDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &agent{config: config, consumer: consumer, state: state}, nil
+}
+
+// run 每隔 interval 扫描一次，once 为 true 时只扫描一次
+func (a *agent) run(ctx context.Context, interval time.Duration, once bool) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := a.scan(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Printf("sa-agent: %s", err)
+		}
+		if once {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchedFile 一次扫描中找到的文件
+type watchedFile struct {
+	id   string
+	path string
+	info os.FileInfo
+}
+
+// scan 发送所有文件中的新数据，清理已消失的文件的进度，并处理发送完毕的轮转文件
+func (a *agent) scan(ctx context.Context) error {
+	files, err := a.list()
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(files))
+	for _, f := range files {
+		seen[f.id] = true
+		fs, ok := a.state.Files[f.id]
+		if !ok {
+			fs = &fileState{Path: f.path}
+			a.state.Files[f.id] = fs
+		} else if fs.Path != f.path {
+			log.Printf("sa-agent: %s was rotated to %s", fs.Path, f.path)
+			fs.Path = f.path
+		}
+		if f.info.Size() < fs.Offset || !fs.sameHead() {
+			log.Printf("sa-agent: %s was truncated or replaced, reading from the beginning", f.path)
+			*fs = fileState{Path: f.path}
+		}
+		if f.info.Size() > fs.Offset {
+			if err := a.ship(ctx, fs); err != nil {
+				a.saveState()
+				return err
+			}
+		}
+	}
+	for id := range a.state.Files {
+		if !seen[id] {
+			delete(a.state.Files, id)
+		}
+	}
+	a.cleanup(files)
+	return a.state.save(a.config.statePath)
+}
+
+// list 返回匹配 pattern 的文件，按修改时间排序，最新的文件在最后
+func (a *agent) list() ([]watchedFile, error) {
+	paths, err := filepath.Glob(filepath.Join(a.config.dir, a.config.pattern))
+	if err != nil {
+		return nil, err
+	}
+	stateName := filepath.Base(a.config.statePath)
+	var files []watchedFile
+	for _, path := range paths {
+		name := filepath.Base(path)
+		if name == stateName || strings.HasPrefix(name, stateName+".tmp") {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, watchedFile{id: fileID(path, info), path: path, info: info})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+	return files, nil
+}
+
+// ship 从 fs.Offset 开始读取完整的行并分批发送，每批发送成功后更新进度。
+// 最后一行没有换行符时说明仍在写入，留到下次扫描。
+func (a *agent) ship(ctx context.Context, fs *fileState) error {
+	f, err := os.Open(fs.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(fs.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReaderSize(f, 64*1024)
+	offset := fs.Offset
+	var batch []string
+	batchBytes := 0
+	flush := func() error {
+		if len(batch) > 0 {
+			if err := a.send(ctx, fs.Path, batch); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+		batchBytes = 0
+		fs.Offset = offset
+		fs.updateHead()
+		return a.saveState()
+	}
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		lineOffset := offset
+		offset += int64(len(line))
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if err := validateLine(line); err != nil {
+			log.Printf("sa-agent: %s at offset %d: skipping invalid line: %s", fs.Path, lineOffset, err)
+			continue
+		}
+		if a.config.maxBatchBytes > 0 && len(batch) > 0 && batchBytes+len(line)+1 > a.config.maxBatchBytes {
+			// 当前行不计入本批，发送后从当前行继续
+			end := offset
+			offset = lineOffset
+			if err := flush(); err != nil {
+				return err
+			}
+			offset = end
+		}
+		batch = append(batch, string(line))
+		batchBytes += len(line) + 1
+		if len(batch) >= a.config.batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+func validateLine(line []byte) error {
+	events, err := sa.DecodeJSON(line)
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		if err := sa.ValidateMessage(e.Raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send 发送一批数据，网络错误及服务器故障时按指数退避无限重试，直到 ctx 取消。
+// 服务器拒绝数据 (4xx) 时重试也不会成功，记录日志后丢弃该批数据。
+func (a *agent) send(ctx context.Context, path string, batch []string) error {
+	delay := a.config.retryMin
+	for {
+		err := a.consumer.SendEncoded(batch)
+		if err == nil {
+			return nil
+		}
+		if !retryable(err) {
+			log.Printf("sa-agent: %s: dropping %d rejected messages: %s", path, len(batch), err)
+			return nil
+		}
+		log.Printf("sa-agent: %s: send failed, retrying in %s: %s", path, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if delay *= 2; delay > a.config.retryMax {
+			delay = a.config.retryMax
+		}
+	}
+}
+
+func retryable(err error) bool {
+	var statusErr *sa.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// cleanup 删除或归档发送完毕且已不再写入的文件，最新的文件始终保留
+func (a *agent) cleanup(files []watchedFile) {
+	if a.config.afterShip == "keep" || len(files) < 2 {
+		return
+	}
+	for _, f := range files[:len(files)-1] {
+		fs := a.state.Files[f.id]
+		info, err := os.Stat(f.path)
+		if err != nil || fs == nil || fs.Offset < info.Size() || time.Since(info.ModTime()) < a.config.idle {
+			continue
+		}
+		if a.config.afterShip == "delete" {
+			err = os.Remove(f.path)
+		} else {
+			err = archive(f.path, a.config.archiveDir)
+		}
+		if err != nil {
+			log.Printf("sa-agent: %s: %s", f.path, err)
+			continue
+		}
+		delete(a.state.Files, f.id)
+	}
+}
+
+// archive 将 path 移动到 dir 中。logrotate 等会重复使用 events.jsonl.1 这样的文件名，
+// 目标已存在时加上时间戳及序号作为后缀，不覆盖之前归档的文件
+func archive(path string, dir string) error {
+	base := filepath.Join(dir, filepath.Base(path))
+	suffix := time.Now().Format("20060102T150405")
+	for i := 0; ; i++ {
+		target := base
+		if i == 1 {
+			target = base + "." + suffix
+		} else if i > 1 {
+			target = fmt.Sprintf("%s.%s.%d", base, suffix, i-1)
+		}
+		// Link 在目标已存在时失败，不会像 Rename 一样覆盖目标
+		err := os.Link(path, target)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return os.Remove(path)
+	}
+}
+
+func (a *agent) saveState() error {
+	if err := a.state.save(a.config.statePath); err != nil {
+		log.Printf("sa-agent: save state: %s", err)
+		return err
+	}
+	return nil
+}
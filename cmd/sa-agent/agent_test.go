@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestArchiveKeepsEarlierArchives(t *testing.T) {
+	dir := t.TempDir()
+	archiveDir := filepath.Join(dir, "archive")
+	if err := os.Mkdir(archiveDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "events.jsonl.1")
+	contents := []string{"first\n", "second\n", "third\n"}
+	for _, content := range contents {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := archive(path, archiveDir); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("%s still exists after archive: %v", path, err)
+		}
+	}
+	entries, err := ioutil.ReadDir(archiveDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		b, err := ioutil.ReadFile(filepath.Join(archiveDir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(b))
+	}
+	sort.Strings(got)
+	sort.Strings(contents)
+	if len(got) != len(contents) {
+		t.Fatalf("archived %q, want %q", got, contents)
+	}
+	for i := range got {
+		if got[i] != contents[i] {
+			t.Fatalf("archived %q, want %q", got, contents)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package main
+
+import "os"
+
+// fileID 无法获取 inode 时以路径标识文件，按重命名方式轮转的文件会被当作新文件
+func fileID(path string, info os.FileInfo) string {
+	return path
+}
@@ -0,0 +1,104 @@
+// Command sa-agent 读取目录中的 JSONL 数据文件并批量发送到接收服务器，
+// 可以代替 LogAgent 使用。每个文件的发送进度保存在状态文件中，重启后从上次的位置继续，
+// 支持按重命名或 copytruncate 方式轮转的文件。
+//
+//	sa-agent -dir /var/log/sa -pattern 'events*.jsonl*' -url "http://host:8106/sa?project=default"
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+func main() {
+	var config agentConfig
+	serverURL := flag.String("url", "", "collector URL, e.g. http://host:8106/sa?project=default")
+	flag.StringVar(&config.dir, "dir", "", "directory of JSONL event files")
+	flag.StringVar(&config.pattern, "pattern", "*.jsonl*", "glob of event files inside -dir")
+	flag.StringVar(&config.statePath, "state", "", "offset state file (default <dir>/.sa-agent.state)")
+	flag.IntVar(&config.batchSize, "batch-size", sa.DefaultMaxBatchSize, "messages per request")
+	flag.IntVar(&config.maxBatchBytes, "max-batch-bytes", 0, "maximum uncompressed bytes per request, 0 for unlimited")
+	useGzip := flag.Bool("gzip", true, "compress data_list with gzip")
+	timeout := flag.Duration("timeout", sa.DefaultTimeout, "HTTP request timeout")
+	interval := flag.Duration("interval", time.Second, "how often to look for new data")
+	flag.DurationVar(&config.retryMin, "retry-min", time.Second, "initial delay between retries")
+	flag.DurationVar(&config.retryMax, "retry-max", time.Minute, "maximum delay between retries")
+	flag.StringVar(&config.afterShip, "after-ship", "keep", "what to do with fully shipped rotated files: keep, delete or archive")
+	flag.StringVar(&config.archiveDir, "archive-dir", "", "destination of archived files (default <dir>/shipped)")
+	flag.DurationVar(&config.idle, "idle", 5*time.Minute, "a rotated file must be unmodified this long before it is deleted or archived")
+	once := flag.Bool("once", false, "ship what is available and exit")
+	flag.Parse()
+
+	if err := config.validate(*serverURL); err != nil {
+		fmt.Fprintf(os.Stderr, "sa-agent: %s\n", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+	consumer, err := sa.NewDefaultConsumer(*serverURL)
+	if err != nil {
+		log.Fatalf("sa-agent: %s", err)
+	}
+	consumer.SetGzip(*useGzip)
+	consumer.SetHTTPClient(sa.NewHTTPClient(sa.HTTPConfig{Timeout: *timeout}))
+
+	a, err := newAgent(config, consumer)
+	if err != nil {
+		log.Fatalf("sa-agent: %s", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := a.run(ctx, *interval, *once); err != nil && err != context.Canceled {
+		log.Fatalf("sa-agent: %s", err)
+	}
+}
+
+// agentConfig sa-agent 的配置
+type agentConfig struct {
+	dir           string
+	pattern       string
+	statePath     string
+	batchSize     int
+	maxBatchBytes int
+	retryMin      time.Duration
+	retryMax      time.Duration
+	afterShip     string
+	archiveDir    string
+	idle          time.Duration
+}
+
+func (c *agentConfig) validate(serverURL string) error {
+	if serverURL == "" {
+		return fmt.Errorf("-url is required")
+	}
+	if c.dir == "" {
+		return fmt.Errorf("-dir is required")
+	}
+	if _, err := filepath.Match(c.pattern, ""); err != nil {
+		return fmt.Errorf("-pattern: %s", err)
+	}
+	if c.statePath == "" {
+		c.statePath = filepath.Join(c.dir, ".sa-agent.state")
+	}
+	if c.batchSize <= 0 {
+		c.batchSize = sa.DefaultMaxBatchSize
+	}
+	switch c.afterShip {
+	case "keep", "delete":
+	case "archive":
+		if c.archiveDir == "" {
+			c.archiveDir = filepath.Join(c.dir, "shipped")
+		}
+	default:
+		return fmt.Errorf("-after-ship must be keep, delete or archive")
+	}
+	return nil
+}
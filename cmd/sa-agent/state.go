@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/internal/atomicfile"
+)
+
+// fileState 一个文件的发送进度
+type fileState struct {
+	// Path 最后一次看到该文件时的路径，轮转后会更新
+	Path string `json:"path"`
+	// Offset 已发送数据的结束位置
+	Offset int64 `json:"offset"`
+	// Head 及 HeadLen 文件开头 HeadLen 字节的哈希，用于发现 copytruncate 后写入了
+	// 同样长度的新内容，或 inode 被新文件复用的情况
+	Head    string `json:"head,omitempty"`
+	HeadLen int    `json:"head_len,omitempty"`
+}
+
+// headSize 计算 Head 使用的最大字节数
+const headSize = 1024
+
+// readHead 返回文件开头 n 字节的哈希，文件不足 n 字节时返回空字符串
+func readHead(path string, n int) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return "", nil
+		}
+		return "", err
+	}
+	sum := sha1.Sum(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// sameHead 判断文件开头是否与记录的一致
+func (fs *fileState) sameHead() bool {
+	if fs.HeadLen == 0 {
+		return true
+	}
+	head, err := readHead(fs.Path, fs.HeadLen)
+	return err == nil && head == fs.Head
+}
+
+// updateHead 已发送的数据超过记录的长度时重新计算 Head
+func (fs *fileState) updateHead() {
+	n := int(fs.Offset)
+	if n > headSize {
+		n = headSize
+	}
+	if n <= fs.HeadLen {
+		return
+	}
+	if head, err := readHead(fs.Path, n); err == nil && head != "" {
+		fs.Head, fs.HeadLen = head, n
+	}
+}
+
+// agentState 所有文件的发送进度，以 fileID 为 key，文件被重命名后仍能找到原来的进度
+type agentState struct {
+	Files map[string]*fileState `json:"files"`
+}
+
+func loadState(path string) (*agentState, error) {
+	s := &agentState{Files: map[string]*fileState{}}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+	if s.Files == nil {
+		s.Files = map[string]*fileState{}
+	}
+	return s, nil
+}
+
+// save 原子写入状态文件，避免进程中断时留下不完整的状态文件
+func (s *agentState) save(path string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.Write(path, b)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+// consumerFlags send 及 import 共用的 Consumer 参数
+type consumerFlags struct {
+	url        string
+	kind       string
+	batchSize  int
+	batchBytes int
+	timeout    time.Duration
+}
+
+func (f *consumerFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.url, "url", "", "collector URL, e.g. http://host:8106/sa?project=default")
+	fs.StringVar(&f.kind, "consumer", "batch", "consumer: default, batch, async, debug, console")
+	fs.IntVar(&f.batchSize, "batch-size", sa.DefaultMaxBatchSize, "messages per request for batch and async consumers")
+	fs.IntVar(&f.batchBytes, "batch-bytes", 0, "max encoded bytes per request for batch and async consumers, 0 for no limit")
+	fs.DurationVar(&f.timeout, "timeout", sa.DefaultTimeout, "HTTP request timeout")
+}
+
+// newConsumer 根据参数创建 Consumer
+func (f *consumerFlags) newConsumer() (sa.Consumer, error) {
+	if f.kind == "console" {
+		return sa.NewConsoleConsumerWithWriter(os.Stdout, sa.ConsoleJSONL), nil
+	}
+	if f.url == "" {
+		return nil, fmt.Errorf("-url is required for the %s consumer", f.kind)
+	}
+	client := sa.NewHTTPClient(sa.HTTPConfig{Timeout: f.timeout})
+	switch f.kind {
+	case "default":
+		c, err := sa.NewDefaultConsumer(f.url)
+		if err != nil {
+			return nil, err
+		}
+		c.SetHTTPClient(client)
+		return c, nil
+	case "batch":
+		c, err := sa.NewBatchConsumerWithConfig(f.url, f.batchConfig())
+		if err != nil {
+			return nil, err
+		}
+		c.SetHTTPClient(client)
+		return c, nil
+	case "async":
+		c, err := sa.NewAsyncBatchConsumerWithConfig(f.url, f.batchConfig())
+		if err != nil {
+			return nil, err
+		}
+		c.SetHTTPClient(client)
+		return c, nil
+	case "debug":
+		c, err := sa.NewDebugConsumer(f.url, false)
+		if err != nil {
+			return nil, err
+		}
+		c.SetHTTPClient(client)
+		return c, nil
+	}
+	return nil, fmt.Errorf("unknown consumer %q", f.kind)
+}
+
+// batchConfig 返回 batch 及 async consumer 共用的批量发送配置
+func (f *consumerFlags) batchConfig() sa.BatchConfig {
+	size := f.batchSize
+	if size <= 0 || size > sa.DefaultMaxBatchSize {
+		size = sa.DefaultMaxBatchSize
+	}
+	return sa.BatchConfig{MaxBatchSize: size, MaxBatchBytes: f.batchBytes}
+}
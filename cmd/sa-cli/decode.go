@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+// runDecode 解码参数或标准输入中的每一行，输出 JSON
+func runDecode(args []string) error {
+	fs := newFlagSet("decode", "[data_list...]")
+	compact := fs.Bool("jsonl", false, "print one compact JSON object per line")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	decode := func(input string) error {
+		events, err := sa.Decode(extractPayload(input))
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			var b []byte
+			if *compact {
+				b, err = json.Marshal(e.Raw)
+			} else {
+				b, err = json.MarshalIndent(e.Raw, "", "    ")
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(b))
+		}
+		return nil
+	}
+	if fs.NArg() > 0 {
+		for _, arg := range fs.Args() {
+			if err := decode(arg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return decodeLines(decode)
+}
+
+func decodeLines(decode func(input string) error) error {
+	var failed int
+	err := eachLine(os.Stdin, func(lineNo int, line []byte) error {
+		if err := decode(string(line)); err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "line %d: %s\n", lineNo, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d lines could not be decoded", failed)
+	}
+	return nil
+}
+
+// extractPayload 从 URL、请求体或 nginx 日志行中取出 data_list 或 data 的值，
+// 不包含这两个参数时原样返回
+func extractPayload(input string) string {
+	input = strings.TrimSpace(input)
+	for _, key := range []string{"data_list=", "data="} {
+		for start := 0; ; {
+			i := strings.Index(input[start:], key)
+			if i < 0 {
+				break
+			}
+			i += start
+			if i == 0 || strings.ContainsRune("?& \"", rune(input[i-1])) {
+				value := input[i+len(key):]
+				if end := strings.IndexAny(value, "& \""); end >= 0 {
+					value = value[:end]
+				}
+				return value
+			}
+			start = i + len(key)
+		}
+	}
+	return input
+}
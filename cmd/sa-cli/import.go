@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+var columnTypes = map[string]sa.ColumnType{
+	"string": sa.ColumnString,
+	"number": sa.ColumnNumber,
+	"bool":   sa.ColumnBool,
+	"list":   sa.ColumnList,
+}
+
+// runImport 导入 CSV 或 JSONL 格式的历史数据，所有事件带有 time_free，
+// 中断 (Ctrl-C) 后使用相同的参数再次执行会从上次的进度继续
+func runImport(args []string) error {
+	fs := newFlagSet("import", "file")
+	var cf consumerFlags
+	cf.register(fs)
+	var config sa.ImportConfig
+	format := fs.String("format", "", "input format: csv or jsonl, detected from the file extension by default")
+	project := fs.String("project", "", "project name, defaults to the project in -url or \"default\"")
+	fs.StringVar(&config.DistinctIDColumn, "distinct-id-column", "distinct_id", "column holding distinct_id")
+	fs.StringVar(&config.EventColumn, "event-column", "event", "column holding the event name")
+	fs.StringVar(&config.TimeColumn, "time-column", "time", "column holding the event time")
+	fs.StringVar(&config.TimeLayout, "time-layout", "", "Go time layout of non-numeric times (default RFC3339)")
+	fs.BoolVar(&config.IsLoginID, "login-id", false, "distinct_id values are login IDs")
+	columns := fs.String("columns", "", "comma-separated property columns, defaults to all other columns")
+	types := fs.String("types", "", "CSV column types, e.g. amount:number,vip:bool,tags:list")
+	fs.StringVar(&config.ListSeparator, "list-separator", ";", "separator of list columns")
+	fs.Float64Var(&config.Rate, "rate", 0, "maximum rows per second, 0 for unlimited")
+	checkpoint := fs.String("checkpoint", "", "progress file (default <file>.checkpoint)")
+	fs.IntVar(&config.CheckpointEvery, "checkpoint-every", sa.DefaultCheckpointEvery, "rows between checkpoints")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("exactly one input file is required")
+	}
+	path := fs.Arg(0)
+
+	switch strings.ToLower(*format) {
+	case "csv":
+		config.Format = sa.ImportCSV
+	case "jsonl", "json":
+		config.Format = sa.ImportJSONL
+	case "":
+		if strings.HasSuffix(strings.ToLower(path), ".csv") {
+			config.Format = sa.ImportCSV
+		}
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if *columns != "" {
+		config.Properties = strings.Split(*columns, ",")
+	}
+	if *types != "" {
+		config.Types = map[string]sa.ColumnType{}
+		for _, spec := range strings.Split(*types, ",") {
+			parts := strings.SplitN(spec, ":", 2)
+			t, ok := columnTypes[parts[len(parts)-1]]
+			if len(parts) != 2 || !ok {
+				return fmt.Errorf("invalid column type %q, expected column:string|number|bool|list", spec)
+			}
+			config.Types[parts[0]] = t
+		}
+	}
+	config.CheckpointPath = *checkpoint
+	if config.CheckpointPath == "" && path != "-" {
+		config.CheckpointPath = path + ".checkpoint"
+	}
+	config.OnError = func(err sa.RowError) {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	if cf.kind == "async" {
+		// 进度在 Flush 后保存，async 的 Flush 不等待发送完成，中断后会跳过未发送的数据
+		return errors.New("import does not support -consumer async, use batch or default")
+	}
+	consumer, err := cf.newConsumer()
+	if err != nil {
+		return err
+	}
+	if *project == "" {
+		*project = projectFromURL(cf.url)
+	}
+	importer, err := sa.NewImporter(consumer, *project, config)
+	if err != nil {
+		return err
+	}
+	in, err := openInput(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	result, err := importer.Import(ctx, in)
+	if closeErr := consumer.Close(); err == nil {
+		err = closeErr
+	}
+	fmt.Fprintf(os.Stderr, "rows %d, imported %d, skipped %d, failed %d\n", result.Rows, result.Imported, result.Skipped, result.Failed)
+	if err != nil {
+		if ctx.Err() != nil && config.CheckpointPath != "" {
+			return fmt.Errorf("interrupted, run again to resume from %s", config.CheckpointPath)
+		}
+		return err
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("%d rows failed", result.Failed)
+	}
+	return nil
+}
+
+// projectFromURL 返回接收服务器地址中的 project 参数，没有时返回 "default"
+func projectFromURL(serverURL string) string {
+	if u, err := url.Parse(serverURL); err == nil {
+		if project := u.Query().Get("project"); project != "" {
+			return project
+		}
+	}
+	return "default"
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// maxLineSize 单行的最大长度，data_list 可能很长
+const maxLineSize = 16 << 20
+
+// openInput 打开文件，path 为空或 "-" 时使用标准输入
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "" || path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// eachLine 对每一行调用 fn，lineNo 从 1 开始，跳过空行
+func eachLine(r io.Reader, fn func(lineNo int, line []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(lineNo, line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,73 @@
+// Command sa-cli 发送、解码及检查神策数据的命令行工具。
+//
+//	sa-cli send -url URL [-consumer batch] [file]     发送 JSONL 格式的数据
+//	sa-cli decode [data_list...]                      将 data/data_list 解码为 JSON
+//	sa-cli validate [file...]                         检查 JSONL 文件中的每一行
+//	sa-cli import -url URL [-rate N] file             导入 CSV 或 JSONL 格式的历史数据
+//	sa-cli ping -url URL                              检查接收服务器是否可用
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"send", "send JSONL messages through a consumer", runSend},
+	{"decode", "decode base64/gzip data or data_list values into JSON", runDecode},
+	{"validate", "check every line of JSONL files and report invalid ones", runValidate},
+	{"import", "import historical CSV or JSONL data with time_free", runImport},
+	{"ping", "check that a collector endpoint accepts data", runPing},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		err := cmd.run(os.Args[2:])
+		switch {
+		case err == nil:
+		case errors.Is(err, flag.ErrHelp):
+			os.Exit(2)
+		default:
+			fmt.Fprintf(os.Stderr, "sa-cli %s: %s\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: sa-cli <command> [flags] [args]")
+	fmt.Fprintln(os.Stderr)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "run 'sa-cli <command> -h' for the flags of a command")
+}
+
+func newFlagSet(name string, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet("sa-cli "+name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: sa-cli %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+// runPing 以 Dry-Run 方式向 Debug API 发送一条数据，检查服务器是否可用，数据不会入库
+func runPing(args []string) error {
+	fs := newFlagSet("ping", "")
+	serverURL := fs.String("url", "", "collector URL, e.g. http://host:8106/sa?project=default")
+	timeout := fs.Duration("timeout", 5*time.Second, "HTTP request timeout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *serverURL == "" {
+		return fmt.Errorf("-url is required")
+	}
+	consumer, err := sa.NewDebugConsumer(*serverURL, false)
+	if err != nil {
+		return err
+	}
+	consumer.SetHTTPClient(sa.NewHTTPClient(sa.HTTPConfig{Timeout: *timeout}))
+	start := time.Now()
+	err = consumer.Send(map[string]interface{}{
+		"type":        "track",
+		"event":       "sa_cli_ping",
+		"distinct_id": "sa-cli",
+		"time":        start.UnixNano() / int64(time.Millisecond),
+		"properties":  map[string]interface{}{},
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("ok %s (%s)\n", *serverURL, time.Since(start).Round(time.Millisecond))
+	return nil
+}
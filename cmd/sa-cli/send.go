@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+// runSend 逐行读取 JSONL 格式的数据并发送，每行可以是单条数据或数据数组
+func runSend(args []string) error {
+	fs := newFlagSet("send", "[file]")
+	var cf consumerFlags
+	cf.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	consumer, err := cf.newConsumer()
+	if err != nil {
+		return err
+	}
+	in, err := openInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var sent, failed int
+	// batch consumer 由 send 自己攒批并通过 SendEncoded 发送，每条数据按所在批次的结果只计入一次，
+	// 避免 BatchConsumer.Send 返回的错误属于之前已缓存的数据
+	var batch *encodedBatch
+	if bc, ok := consumer.(*sa.BatchConsumer); ok {
+		batch = &encodedBatch{consumer: bc}
+	}
+	flush := func() {
+		n, err := batch.flush()
+		if err != nil {
+			failed += n
+			fmt.Fprintf(os.Stderr, "lines %d-%d: %s\n", batch.firstLine, batch.lastLine, err)
+		} else {
+			sent += n
+		}
+	}
+	readErr := eachLine(in, func(lineNo int, line []byte) error {
+		events, err := sa.DecodeJSON(line)
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "line %d: %s\n", lineNo, err)
+			return nil
+		}
+		for _, e := range events {
+			// 秒级的 time 在这里转换为毫秒，之后发送转换后的数据
+			msg, err := sa.NormalizeMessage(e.Raw)
+			if err != nil {
+				failed++
+				fmt.Fprintf(os.Stderr, "line %d: %s\n", lineNo, err)
+				continue
+			}
+			if batch != nil {
+				s, err := json.Marshal(msg)
+				if err != nil {
+					failed++
+					fmt.Fprintf(os.Stderr, "line %d: %s\n", lineNo, err)
+					continue
+				}
+				if !batch.fits(string(s)) {
+					flush()
+				}
+				batch.add(lineNo, string(s))
+				continue
+			}
+			if err := consumer.Send(msg); err != nil {
+				failed++
+				fmt.Fprintf(os.Stderr, "line %d: %s\n", lineNo, err)
+				continue
+			}
+			sent++
+		}
+		return nil
+	})
+	if batch != nil {
+		flush()
+	}
+	closeErr := consumer.Close()
+	if closeErr != nil {
+		fmt.Fprintf(os.Stderr, "close: %s\n", closeErr)
+	}
+	if async, ok := consumer.(*sa.AsyncBatchConsumer); ok {
+		// AsyncBatchConsumer 在后台发送，失败的数据只体现在统计中
+		stats := async.Stats()
+		sent -= int(stats.Failed)
+		failed += int(stats.Failed)
+	}
+	fmt.Fprintf(os.Stderr, "sent %d, failed %d\n", sent, failed)
+	if readErr != nil {
+		return readErr
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d messages failed", failed)
+	}
+	return closeErr
+}
+
+// encodedBatch send 为 batch consumer 攒的一批已编码的数据，按 consumer 的条数及字节数上限分批
+type encodedBatch struct {
+	consumer  *sa.BatchConsumer
+	msgs      []string
+	firstLine int
+	lastLine  int
+}
+
+func (b *encodedBatch) add(lineNo int, msg string) {
+	if len(b.msgs) == 0 {
+		b.firstLine = lineNo
+	}
+	b.lastLine = lineNo
+	b.msgs = append(b.msgs, msg)
+}
+
+// fits 判断 msg 能否加入当前批次
+func (b *encodedBatch) fits(msg string) bool {
+	return b.consumer.Fits(b.msgs, msg)
+}
+
+// flush 发送当前批次，返回批次中的数据条数及发送结果
+func (b *encodedBatch) flush() (int, error) {
+	n := len(b.msgs)
+	err := b.consumer.SendEncoded(b.msgs)
+	b.msgs = b.msgs[:0]
+	return n, err
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/satest"
+)
+
+func TestSendCountsEachMessageOnce(t *testing.T) {
+	tests := []struct {
+		name     string
+		failNext int
+		lines    []string
+		wantErr  string
+		wantSent int
+	}{
+		{
+			name:     "all sent",
+			lines:    []string{event("u1"), event("u2"), event("u3")},
+			wantSent: 3,
+		},
+		{
+			name:     "first batch rejected",
+			failNext: 1,
+			lines:    []string{event("u1"), event("u2"), event("u3")},
+			wantErr:  "2 messages failed",
+			wantSent: 1,
+		},
+		{
+			name:     "invalid line and rejected batch",
+			failNext: 1,
+			lines:    []string{event("u1"), `{"type":"track"}`, event("u2"), event("u3")},
+			wantErr:  "3 messages failed",
+			wantSent: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := satest.NewCollector()
+			defer collector.Close()
+			collector.FailNext(tt.failNext, 500)
+			path := writeLines(t, tt.lines...)
+			err := runSend([]string{"-url", collector.URL(), "-batch-size", "2", path})
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("runSend: got %v, want %q", err, tt.wantErr)
+			}
+			if got := len(collector.Events()); got != tt.wantSent {
+				t.Errorf("collector received %d messages, want %d", got, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestSendNormalizesSecondTimestamps(t *testing.T) {
+	for _, consumer := range []string{"batch", "default"} {
+		t.Run(consumer, func(t *testing.T) {
+			collector := satest.NewCollector()
+			defer collector.Close()
+			path := writeLines(t, `{"type":"track","event":"Buy","distinct_id":"u1","time":1600000000,"properties":{}}`)
+			if err := runSend([]string{"-url", collector.URL(), "-consumer", consumer, path}); err != nil {
+				t.Fatal(err)
+			}
+			events := collector.Events()
+			if len(events) != 1 || events[0].Time != 1600000000000 {
+				t.Errorf("collector received %+v, want time 1600000000000", events)
+			}
+		})
+	}
+}
+
+func TestSendBatchBytes(t *testing.T) {
+	collector := satest.NewCollector()
+	defer collector.Close()
+	var lines []string
+	for i := 0; i < 6; i++ {
+		lines = append(lines, event("u1"))
+	}
+	path := writeLines(t, lines...)
+	// 两条数据编码为 data_list 后为 236 字节，三条为 352 字节
+	if err := runSend([]string{"-url", collector.URL(), "-batch-size", "5", "-batch-bytes", "300", path}); err != nil {
+		t.Fatal(err)
+	}
+	var sizes []int
+	for _, r := range collector.Requests() {
+		sizes = append(sizes, r.Messages)
+	}
+	if len(collector.Events()) != 6 || len(sizes) != 3 || sizes[0] != 2 || sizes[1] != 2 || sizes[2] != 2 {
+		t.Errorf("requests carried %v messages, want 3 requests of 2", sizes)
+	}
+}
+
+func writeLines(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func event(distinctID string) string {
+	return `{"type":"track","event":"Buy","distinct_id":"` + distinctID + `","time":1600000000000,"properties":{}}`
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+// runValidate 按服务器的规则检查 JSONL 文件的每一行，输出所有有误的行
+func runValidate(args []string) error {
+	fs := newFlagSet("validate", "[file...]")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	var total, invalid int
+	for _, path := range paths {
+		name := path
+		if path == "-" {
+			name = "stdin"
+		}
+		in, err := openInput(path)
+		if err != nil {
+			return err
+		}
+		err = eachLine(in, func(lineNo int, line []byte) error {
+			total++
+			if err := validateLine(line); err != nil {
+				invalid++
+				fmt.Printf("%s:%d: %s\n", name, lineNo, err)
+			}
+			return nil
+		})
+		in.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%d lines, %d invalid\n", total, invalid)
+	if invalid > 0 {
+		return fmt.Errorf("%d invalid lines", invalid)
+	}
+	return nil
+}
+
+func validateLine(line []byte) error {
+	events, err := sa.DecodeJSON(line)
+	if err != nil {
+		return err
+	}
+	for i, e := range events {
+		if err := sa.ValidateMessage(e.Raw); err != nil {
+			if len(events) > 1 {
+				return fmt.Errorf("message[%d]: %w", i, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+// Command sa-proxy 与接收服务器兼容的转发服务，接收 SDK 及 Web、App 端发送的
+// data/data_list 请求，检查并补充服务端信息后通过 AsyncBatchConsumer 批量转发。
+// 设置 -spool 后发送失败的数据写入本地文件，之后由 sa-agent 补发。
+//
+//	sa-proxy -listen :8106 -url "http://collector:8106/sa?project=default" -spool /var/spool/sa/events.jsonl
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+func main() {
+	listen := flag.String("listen", ":8106", "address to listen on")
+	adminListen := flag.String("admin-listen", "127.0.0.1:8107", "address serving /stats and /healthz, empty to disable")
+	upstream := flag.String("url", "", "upstream collector URL, e.g. http://collector:8106/sa?project=default")
+	spool := flag.String("spool", "", "JSONL file receiving every batch that cannot be sent upstream")
+	batchSize := flag.Int("batch-size", sa.DefaultMaxBatchSize, "messages per upstream request")
+	bufferSize := flag.Int("buffer-size", 10000, "messages buffered in memory, requests wait while it is full")
+	workers := flag.Int("workers", 4, "parallel upstream requests")
+	flushInterval := flag.Duration("flush-interval", time.Second, "maximum time a message waits in the buffer")
+	useGzip := flag.Bool("gzip", true, "compress upstream data_list with gzip")
+	timeout := flag.Duration("timeout", sa.DefaultTimeout, "upstream request timeout")
+	maxBody := flag.Int64("max-body", 10<<20, "maximum request body size in bytes")
+	trustForwarded := flag.Bool("trust-forwarded", false, "take the client IP from X-Forwarded-For / X-Real-IP")
+	allowOrigin := flag.String("allow-origin", "", "comma-separated origins allowed to send cross-origin requests, * for any")
+	allowCredentials := flag.Bool("allow-credentials", false, "allow cross-origin requests with cookies, requires explicit -allow-origin")
+	flag.Parse()
+	if *upstream == "" {
+		fmt.Fprintln(os.Stderr, "sa-proxy: -url is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	cors, err := newCORSPolicy(*allowOrigin, *allowCredentials)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sa-proxy: %s\n", err)
+		os.Exit(2)
+	}
+
+	consumer, closeSpool, err := newUpstream(upstreamConfig{
+		url: *upstream,
+		batch: sa.BatchConfig{
+			MaxBatchSize:  *batchSize,
+			BufferSize:    *bufferSize,
+			Workers:       *workers,
+			FlushInterval: *flushInterval,
+			Gzip:          *useGzip,
+		},
+		timeout: *timeout,
+		spool:   *spool,
+	})
+	if err != nil {
+		log.Fatalf("sa-proxy: %s", err)
+	}
+	defer closeSpool()
+
+	p := newProxy(consumer, *maxBody, *trustForwarded)
+	p.cors = cors
+	servers := []*http.Server{{
+		Addr:              *listen,
+		Handler:           p.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}}
+	if *adminListen != "" {
+		servers = append(servers, &http.Server{
+			Addr:              *adminListen,
+			Handler:           p.adminRoutes(),
+			ReadHeaderTimeout: 10 * time.Second,
+		})
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		for _, server := range servers {
+			server.Shutdown(shutdownCtx)
+		}
+	}()
+	errCh := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			errCh <- server.ListenAndServe()
+		}(server)
+	}
+	log.Printf("sa-proxy: listening on %s, forwarding to %s", *listen, *upstream)
+	for range servers {
+		if err := <-errCh; err != nil && err != http.ErrServerClosed {
+			log.Printf("sa-proxy: %s", err)
+			stop()
+		}
+	}
+	// 发送缓冲区中剩余的数据
+	if err := consumer.Close(); err != nil {
+		log.Printf("sa-proxy: close: %s", err)
+	}
+}
+
+// upstreamConfig 转发到接收服务器的配置
+type upstreamConfig struct {
+	url     string
+	batch   sa.BatchConfig
+	timeout time.Duration
+	// spool 为空时发送失败的数据只计入统计
+	spool string
+}
+
+// newUpstream 创建转发数据的 AsyncBatchConsumer。设置了 spool 时，熔断器打开及发送失败的每个 batch
+// 都写入 spool 文件，返回的函数用于关闭该文件
+func newUpstream(config upstreamConfig) (*sa.AsyncBatchConsumer, func() error, error) {
+	consumer, err := sa.NewAsyncBatchConsumerWithConfig(config.url, config.batch)
+	if err != nil {
+		return nil, nil, err
+	}
+	consumer.SetHTTPClient(sa.NewHTTPClient(sa.HTTPConfig{Timeout: config.timeout}))
+	if config.spool == "" {
+		return consumer, func() error { return nil }, nil
+	}
+	f, err := os.OpenFile(config.spool, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		consumer.Close()
+		return nil, nil, err
+	}
+	// 熔断器打开时不再等待接收服务器超时，直接写入 spool
+	consumer.SetCircuitBreaker(sa.NewCircuitBreaker(sa.BreakerConfig{
+		OnStateChange: func(from sa.BreakerState, to sa.BreakerState) {
+			log.Printf("sa-proxy: upstream circuit breaker %s -> %s", from, to)
+		},
+	}))
+	consumer.SetFallback(sa.NewConsoleConsumerWithWriter(f, sa.ConsoleJSONL))
+	consumer.SetFallbackOnError(true)
+	return consumer, f.Close, nil
+}
+
+// corsPolicy 允许跨域发送数据的来源
+type corsPolicy struct {
+	anyOrigin   bool
+	origins     map[string]bool
+	credentials bool
+}
+
+// newCORSPolicy 解析 -allow-origin，"*" 表示允许任何来源，此时不能允许携带 cookie
+func newCORSPolicy(allowOrigin string, credentials bool) (corsPolicy, error) {
+	policy := corsPolicy{origins: map[string]bool{}, credentials: credentials}
+	for _, origin := range strings.Split(allowOrigin, ",") {
+		origin = strings.TrimSpace(origin)
+		switch origin {
+		case "":
+		case "*":
+			policy.anyOrigin = true
+		default:
+			policy.origins[origin] = true
+		}
+	}
+	if credentials && policy.anyOrigin {
+		return policy, fmt.Errorf("-allow-credentials requires explicit origins, not *")
+	}
+	return policy, nil
+}
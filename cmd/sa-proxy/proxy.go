@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+// proxy 接收数据并转发给 consumer
+type proxy struct {
+	consumer       *sa.AsyncBatchConsumer
+	maxBody        int64
+	trustForwarded bool
+	cors           corsPolicy
+	now            func() time.Time
+
+	requests int64
+	received int64
+	invalid  int64
+	rejected int64
+}
+
+// proxyStats /stats 返回的统计
+type proxyStats struct {
+	// Requests 收到的数据请求数
+	Requests int64 `json:"requests"`
+	// Received 收到的合法数据条数
+	Received int64 `json:"received"`
+	// Invalid 格式有误被丢弃的数据条数
+	Invalid int64 `json:"invalid"`
+	// Rejected 无法解码或全部数据有误的请求数
+	Rejected int64              `json:"rejected"`
+	Upstream sa.AsyncBatchStats `json:"upstream"`
+}
+
+func newProxy(consumer *sa.AsyncBatchConsumer, maxBody int64, trustForwarded bool) *proxy {
+	return &proxy{consumer: consumer, maxBody: maxBody, trustForwarded: trustForwarded, now: time.Now}
+}
+
+// routes 对外提供的接口，只接收数据及健康检查
+func (p *proxy) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/", p.collect)
+	return mux
+}
+
+// adminRoutes 只在内部地址上提供的接口，/stats 不对外暴露
+func (p *proxy) adminRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/stats", p.stats)
+	return mux
+}
+
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (p *proxy) stats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(proxyStats{
+		Requests: atomic.LoadInt64(&p.requests),
+		Received: atomic.LoadInt64(&p.received),
+		Invalid:  atomic.LoadInt64(&p.invalid),
+		Rejected: atomic.LoadInt64(&p.rejected),
+		Upstream: p.consumer.Stats(),
+	})
+}
+
+// collect 处理与接收服务器相同的 GET/POST data、data_list 请求。
+// data_list 中部分数据有误时丢弃有误的数据并返回 200，全部有误时返回 400。
+func (p *proxy) collect(w http.ResponseWriter, r *http.Request) {
+	p.setCORSHeaders(w, r)
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodGet, http.MethodPost:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	atomic.AddInt64(&p.requests, 1)
+	r.Body = http.MaxBytesReader(w, r.Body, p.maxBody)
+
+	events, err := sa.DecodeRequest(r)
+	if err != nil {
+		atomic.AddInt64(&p.rejected, 1)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ip := p.clientIP(r)
+	project := r.URL.Query().Get("project")
+	receivedAt := p.now().UnixNano() / int64(time.Millisecond)
+	accepted := 0
+	var lastErr error
+	for _, e := range events {
+		msg := e.Raw
+		// 先补充再检查，请求中指定的项目等补充的字段同样需要检查
+		enrich(msg, ip, project, receivedAt)
+		if err := sa.ValidateMessage(msg); err != nil {
+			atomic.AddInt64(&p.invalid, 1)
+			lastErr = err
+			continue
+		}
+		if err := p.consumer.Send(msg); err != nil {
+			if errors.Is(err, sa.ErrConsumerClosed) {
+				http.Error(w, "shutting down", http.StatusServiceUnavailable)
+				return
+			}
+			lastErr = err
+			continue
+		}
+		accepted++
+	}
+	atomic.AddInt64(&p.received, int64(accepted))
+	if accepted == 0 && lastErr != nil {
+		atomic.AddInt64(&p.rejected, 1)
+		http.Error(w, lastErr.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// setCORSHeaders Web 端通过 XHR/sendBeacon 跨域发送时，只对 -allow-origin 中的来源返回 CORS 响应头
+func (p *proxy) setCORSHeaders(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	w.Header().Add("Vary", "Origin")
+	if p.cors.origins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if p.cors.credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		return
+	}
+	if p.cors.anyOrigin {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+}
+
+// enrich 补充服务端信息：客户端 IP ($ip)、接收时间 (recv_time) 及请求中指定的项目，
+// 数据中已有的值不覆盖
+func enrich(msg map[string]interface{}, ip string, project string, receivedAt int64) {
+	msg["recv_time"] = receivedAt
+	if _, ok := msg["project"]; !ok && project != "" {
+		msg["project"] = project
+	}
+	properties, ok := msg["properties"].(map[string]interface{})
+	if !ok {
+		properties = map[string]interface{}{}
+		msg["properties"] = properties
+	}
+	if _, ok := properties["$ip"]; !ok && ip != "" {
+		properties["$ip"] = ip
+	}
+}
+
+// clientIP 返回客户端 IP，trustForwarded 为 true 时优先使用代理设置的请求头
+func (p *proxy) clientIP(r *http.Request) string {
+	if p.trustForwarded {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			return strings.TrimSpace(realIP)
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
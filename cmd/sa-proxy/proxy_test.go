@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/satest"
+)
+
+// postEvents 以 data_list 方式向 handler 发送 distinct_id 为 ids 的事件
+func postEvents(t *testing.T, handler http.Handler, ids []string) {
+	t.Helper()
+	var msgs []map[string]interface{}
+	for _, id := range ids {
+		msgs = append(msgs, map[string]interface{}{
+			"type": "track", "event": "Buy", "distinct_id": id, "time": 1600000000000,
+			"properties": map[string]interface{}{},
+		})
+	}
+	b, _ := json.Marshal(msgs)
+	form := url.Values{"data_list": {base64.StdEncoding.EncodeToString(b)}}
+	req := httptest.NewRequest(http.MethodPost, "/sa?project=default", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("collect: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestNothingLostWhenUpstreamDies(t *testing.T) {
+	collector := satest.NewCollector()
+	spool := filepath.Join(t.TempDir(), "spool.jsonl")
+	consumer, closeSpool, err := newUpstream(upstreamConfig{
+		url:     collector.URL(),
+		batch:   sa.BatchConfig{MaxBatchSize: 5, FlushInterval: 10 * time.Millisecond, Workers: 2},
+		timeout: time.Second,
+		spool:   spool,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newProxy(consumer, 1<<20, false).routes()
+
+	var ids []string
+	for i := 0; i < 40; i++ {
+		ids = append(ids, fmt.Sprintf("u%d", i))
+	}
+	postEvents(t, handler, ids[:20])
+	// 等待前一半数据发送完成后停止接收服务器
+	for deadline := time.Now().Add(5 * time.Second); consumer.Stats().Sent < 20; {
+		if time.Now().After(deadline) {
+			t.Fatalf("upstream stats: %+v", consumer.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	collector.Close()
+	postEvents(t, handler, ids[20:])
+	if err := consumer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := closeSpool(); err != nil {
+		t.Fatal(err)
+	}
+
+	delivered := map[string]bool{}
+	for _, e := range collector.Events() {
+		delivered[e.DistinctID] = true
+	}
+	f, err := os.Open(spool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	spooled := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		events, err := sa.DecodeJSON(scanner.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range events {
+			delivered[e.DistinctID] = true
+			spooled++
+		}
+	}
+	for _, id := range ids {
+		if !delivered[id] {
+			t.Errorf("%s was neither delivered nor spooled", id)
+		}
+	}
+	if spooled == 0 {
+		t.Error("nothing was spooled after the upstream stopped")
+	}
+	if stats := consumer.Stats(); stats.Failed != 0 {
+		t.Errorf("upstream stats: %+v, want no failed messages", stats)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name            string
+		allowOrigin     string
+		credentials     bool
+		origin          string
+		wantOrigin      string
+		wantCredentials string
+	}{
+		{"disabled by default", "", false, "https://evil.example", "", ""},
+		{"allowlisted", "https://a.example, https://b.example", false, "https://b.example", "https://b.example", ""},
+		{"not allowlisted", "https://a.example", true, "https://evil.example", "", ""},
+		{"credentials for allowlisted", "https://a.example", true, "https://a.example", "https://a.example", "true"},
+		{"any origin", "*", false, "https://evil.example", "*", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cors, err := newCORSPolicy(tt.allowOrigin, tt.credentials)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := newProxy(nil, 1<<20, false)
+			p.cors = cors
+			req := httptest.NewRequest(http.MethodOptions, "/sa", nil)
+			req.Header.Set("Origin", tt.origin)
+			rec := httptest.NewRecorder()
+			p.routes().ServeHTTP(rec, req)
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+				t.Errorf("Access-Control-Allow-Credentials = %q, want %q", got, tt.wantCredentials)
+			}
+		})
+	}
+	if _, err := newCORSPolicy("*", true); err == nil {
+		t.Error("-allow-origin * with -allow-credentials: want error")
+	}
+}
+
+func TestStatsOnlyOnAdminRoutes(t *testing.T) {
+	collector := satest.NewCollector()
+	defer collector.Close()
+	consumer, _, err := newUpstream(upstreamConfig{url: collector.URL(), timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+	p := newProxy(consumer, 1<<20, false)
+
+	rec := httptest.NewRecorder()
+	p.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if rec.Code == http.StatusOK {
+		t.Errorf("public /stats: got 200 %s", rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	p.adminRoutes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var stats proxyStats
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &stats) != nil {
+		t.Errorf("admin /stats: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestProjectFromQueryIsValidated(t *testing.T) {
+	collector := satest.NewCollector()
+	defer collector.Close()
+	consumer, _, err := newUpstream(upstreamConfig{url: collector.URL(), timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newProxy(consumer, 1<<20, false)
+	handler := p.routes()
+	send := func(query string, msg map[string]interface{}) int {
+		b, _ := json.Marshal(msg)
+		req := httptest.NewRequest(http.MethodGet, "/sa?data="+url.QueryEscape(base64.StdEncoding.EncodeToString(b))+"&"+query, nil)
+		req.RemoteAddr = "203.0.113.7:1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	msg := func(id string) map[string]interface{} {
+		return map[string]interface{}{"type": "track", "event": "Buy", "distinct_id": id, "time": 1600000000000, "properties": map[string]interface{}{}}
+	}
+
+	if code := send("project=not-a-name", msg("u1")); code != http.StatusBadRequest {
+		t.Errorf("invalid project in the query: got %d, want 400", code)
+	}
+	if n := atomic.LoadInt64(&p.invalid); n != 1 {
+		t.Errorf("invalid count %d, want 1", n)
+	}
+	if code := send("project=prod", msg("u2")); code != http.StatusOK {
+		t.Errorf("valid project: got %d", code)
+	}
+	// 数据中的项目优先，查询参数不会覆盖
+	own := msg("u3")
+	own["project"] = "own"
+	if code := send("project=not-a-name", own); code != http.StatusOK {
+		t.Errorf("project in the message: got %d", code)
+	}
+	if err := consumer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, e := range collector.Events() {
+		got[e.DistinctID] = e.Project
+		if e.Properties["$ip"] != "203.0.113.7" {
+			t.Errorf("%s: $ip = %v", e.DistinctID, e.Properties["$ip"])
+		}
+	}
+	if want := map[string]string{"u2": "prod", "u3": "own"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered projects %v, want %v", got, want)
+	}
+}
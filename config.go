@@ -1 +1,61 @@
 package sensorsanalytics
+
+import "time"
+
+const (
+	// DefaultFlushInterval AsyncBatchConsumer 默认的定时发送间隔
+	DefaultFlushInterval = 30 * time.Second
+	// DefaultMaxBatchSize 默认单个请求发送的最大数据条数
+	DefaultMaxBatchSize = 50
+	// DefaultBufferSize AsyncBatchConsumer 默认的接收数据缓冲区大小
+	DefaultBufferSize = 1000
+	// DefaultWorkers AsyncBatchConsumer 默认的发送线程数
+	DefaultWorkers = 1
+	// DefaultTimeout 默认的单次请求超时时间
+	DefaultTimeout = 10 * time.Second
+	// DefaultDialTimeout 默认的建立连接超时时间
+	DefaultDialTimeout = 5 * time.Second
+	// DefaultMaxIdleConnsPerHost 默认每个服务器保持的空闲连接数
+	DefaultMaxIdleConnsPerHost = 16
+)
+
+// BatchConfig 批量发送数据的 Consumer 的配置，零值字段使用默认值
+type BatchConfig struct {
+	// FlushInterval 定时发送的间隔，仅 AsyncBatchConsumer 使用
+	FlushInterval time.Duration
+	// MaxBatchSize 单个请求发送的最大数据条数
+	MaxBatchSize int
+	// MaxBatchBytes 单个请求中 data_list 编码后的最大字节数，为 0 时不限制。
+	// 单条数据本身超过该大小时会被单独发送。
+	MaxBatchBytes int
+	// BufferSize 接收数据缓冲区大小，仅 AsyncBatchConsumer 使用
+	BufferSize int
+	// Workers 并行发送 batch 的线程数，仅 AsyncBatchConsumer 使用
+	Workers int
+	// QueueSize 等待发送的 batch 队列长度，默认与 Workers 相同，仅 AsyncBatchConsumer 使用
+	QueueSize int
+	// Gzip data_list 使用 gzip 压缩后发送，MaxBatchBytes 按压缩前的大小计算
+	Gzip bool
+}
+
+func (bc BatchConfig) withDefaults() BatchConfig {
+	if bc.FlushInterval <= 0 {
+		bc.FlushInterval = DefaultFlushInterval
+	}
+	if bc.MaxBatchSize <= 0 {
+		bc.MaxBatchSize = DefaultMaxBatchSize
+	}
+	if bc.MaxBatchBytes < 0 {
+		bc.MaxBatchBytes = 0
+	}
+	if bc.BufferSize <= 0 {
+		bc.BufferSize = DefaultBufferSize
+	}
+	if bc.Workers <= 0 {
+		bc.Workers = DefaultWorkers
+	}
+	if bc.QueueSize <= 0 {
+		bc.QueueSize = bc.Workers
+	}
+	return bc
+}
@@ -0,0 +1,221 @@
+package sensorsanalytics_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+// testCollector 记录每个请求中数据条数的接收服务器
+type testCollector struct {
+	*httptest.Server
+	lock       sync.Mutex
+	sizes      []int
+	received   int
+	latency    time.Duration
+	failNext   int
+	failStatus int
+}
+
+func newTestCollector() *testCollector {
+	c := &testCollector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(c.handle))
+	return c
+}
+
+func (c *testCollector) handle(w http.ResponseWriter, r *http.Request) {
+	// BatchConsumer 发送的 Content-Type 不是表单，直接解析请求体
+	body, _ := ioutil.ReadAll(r.Body)
+	form, _ := url.ParseQuery(string(body))
+	for k, v := range r.URL.Query() {
+		form[k] = v
+	}
+	c.lock.Lock()
+	latency := c.latency
+	fail := c.failNext > 0
+	if fail {
+		c.failNext--
+	}
+	c.lock.Unlock()
+	time.Sleep(latency)
+	if fail {
+		w.WriteHeader(c.failStatus)
+		return
+	}
+	n, err := countMessages(form)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.lock.Lock()
+	c.sizes = append(c.sizes, n)
+	c.received += n
+	c.lock.Unlock()
+}
+
+func countMessages(form url.Values) (int, error) {
+	if form.Get("data") != "" {
+		return 1, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(form.Get("data_list"))
+	if err != nil {
+		return 0, err
+	}
+	if form.Get("gzip") == "1" {
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return 0, err
+		}
+		if b, err = ioutil.ReadAll(zr); err != nil {
+			return 0, err
+		}
+	}
+	var msgs []json.RawMessage
+	err = json.Unmarshal(b, &msgs)
+	return len(msgs), err
+}
+
+// requestSizes 返回成功的请求中的数据条数
+func (c *testCollector) requestSizes() []int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]int(nil), c.sizes...)
+}
+
+func (c *testCollector) receivedCount() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.received
+}
+
+// waitReceived 等待收到 n 条数据，最多等待 2 秒
+func (c *testCollector) waitReceived(n int) {
+	deadline := time.Now().Add(2 * time.Second)
+	for c.receivedCount() < n && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func trackMsg(distinctID string, props map[string]interface{}) map[string]interface{} {
+	if props == nil {
+		props = map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"type":        "track",
+		"event":       "OrderPaid",
+		"distinct_id": distinctID,
+		"time":        time.Now().Unix() * 1000,
+		"properties":  props,
+	}
+}
+
+func TestBatchConsumerLimits(t *testing.T) {
+	// 每条数据 data_list 编码后约 1.4KB
+	padded := map[string]interface{}{"pad": strings.Repeat("x", 1000)}
+	tests := []struct {
+		name   string
+		config sa.BatchConfig
+		props  map[string]interface{}
+		count  int
+		want   []int
+	}{
+		{"max batch size", sa.BatchConfig{MaxBatchSize: 3}, nil, 7, []int{3, 3, 1}},
+		{"max batch bytes", sa.BatchConfig{MaxBatchBytes: 4000}, padded, 5, []int{2, 2, 1}},
+		{"message larger than max batch bytes", sa.BatchConfig{MaxBatchBytes: 100}, padded, 3, []int{1, 1, 1}},
+		{"size reached before bytes", sa.BatchConfig{MaxBatchSize: 2, MaxBatchBytes: 1 << 20}, padded, 3, []int{2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCollector()
+			defer c.Close()
+			consumer, err := sa.NewBatchConsumerWithConfig(c.URL, tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.count; i++ {
+				if err := consumer.Send(trackMsg("u1", tt.props)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := consumer.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := c.requestSizes(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("request sizes %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchConsumerFits(t *testing.T) {
+	msg := strings.Repeat("x", 98)
+	consumer, _ := sa.NewBatchConsumerWithConfig("http://localhost/sa", sa.BatchConfig{MaxBatchSize: 3, MaxBatchBytes: 300})
+	// 两条数据编码为 data_list 后为 268 字节，三条为 400 字节
+	if !consumer.Fits(nil, msg) || !consumer.Fits([]string{msg}, msg) || consumer.Fits([]string{msg, msg}, msg) {
+		t.Error("Fits does not follow MaxBatchBytes")
+	}
+	consumer, _ = sa.NewBatchConsumerWithConfig("http://localhost/sa", sa.BatchConfig{MaxBatchSize: 2})
+	if !consumer.Fits([]string{msg}, msg) || consumer.Fits([]string{msg, msg}, msg) {
+		t.Error("Fits does not follow MaxBatchSize")
+	}
+	// 单条数据超过上限时也可以单独发送
+	consumer, _ = sa.NewBatchConsumerWithConfig("http://localhost/sa", sa.BatchConfig{MaxBatchBytes: 10})
+	if !consumer.Fits(nil, msg) {
+		t.Error("Fits rejected a message for an empty batch")
+	}
+}
+
+func TestAsyncBatchConsumerFlushInterval(t *testing.T) {
+	c := newTestCollector()
+	defer c.Close()
+	consumer, err := sa.NewAsyncBatchConsumerWithConfig(c.URL, sa.BatchConfig{FlushInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+	for i := 0; i < 3; i++ {
+		consumer.Send(trackMsg("u1", nil))
+	}
+	c.waitReceived(3)
+	if got := c.requestSizes(); !reflect.DeepEqual(got, []int{3}) {
+		t.Errorf("request sizes before Close %v, want [3]", got)
+	}
+}
+
+// 因数据量触发发送后重新计时，定时发送不会紧接着发送一个很小的 batch
+func TestAsyncBatchConsumerFlushIntervalRestarts(t *testing.T) {
+	c := newTestCollector()
+	defer c.Close()
+	const interval = 200 * time.Millisecond
+	consumer, err := sa.NewAsyncBatchConsumerWithConfig(c.URL, sa.BatchConfig{MaxBatchSize: 2, FlushInterval: interval})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+	start := time.Now()
+	time.Sleep(interval * 7 / 10)
+	for i := 0; i < 3; i++ {
+		consumer.Send(trackMsg("u1", nil))
+	}
+	// 未重新计时时第三条数据会在 interval 时发送
+	time.Sleep(time.Until(start.Add(interval * 13 / 10)))
+	if got := c.requestSizes(); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("request sizes %v, want [2]", got)
+	}
+	c.waitReceived(3)
+	if got := c.requestSizes(); !reflect.DeepEqual(got, []int{2, 1}) {
+		t.Errorf("request sizes %v, want [2 1]", got)
+	}
+}
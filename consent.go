@@ -0,0 +1,191 @@
+package sensorsanalytics
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/internal/atomicfile"
+)
+
+// ConsentCategory 数据用途分类
+type ConsentCategory string
+
+const (
+	// ConsentAll 所有用途，用户撤回所有同意时使用
+	ConsentAll ConsentCategory = "*"
+	// ConsentAnalytics 用于统计分析
+	ConsentAnalytics ConsentCategory = "analytics"
+	// ConsentMarketing 用于营销
+	ConsentMarketing ConsentCategory = "marketing"
+)
+
+// ConsentStore 记录用户撤回同意的数据用途，Client 在每次发送前检查
+type ConsentStore interface {
+	// IsOptedOut 判断用户是否撤回了对 category 的同意
+	IsOptedOut(distinctID string, category ConsentCategory) (bool, error)
+	// OptOut 撤回对 categories 的同意，categories 为空时撤回所有用途
+	OptOut(distinctID string, categories ...ConsentCategory) error
+	// OptIn 恢复对 categories 的同意，categories 为空时恢复所有用途
+	OptIn(distinctID string, categories ...ConsentCategory) error
+}
+
+// Purger 由缓存数据的 Consumer 实现，删除指定用户尚未发送的数据，返回删除的条数
+type Purger interface {
+	Purge(distinctID string) int
+}
+
+// purge 在 consumer 实现了 Purger 时删除指定用户尚未发送的数据
+func purge(consumer Consumer, distinctID string) int {
+	if p, ok := consumer.(Purger); ok {
+		return p.Purge(distinctID)
+	}
+	return 0
+}
+
+// MemoryConsentStore 保存在内存中的 ConsentStore
+type MemoryConsentStore struct {
+	lock     sync.RWMutex
+	optedOut map[string]map[ConsentCategory]bool
+}
+
+// NewMemoryConsentStore 创建新的 MemoryConsentStore
+func NewMemoryConsentStore() *MemoryConsentStore {
+	return &MemoryConsentStore{
+		optedOut: map[string]map[ConsentCategory]bool{},
+	}
+}
+
+// IsOptedOut 判断用户是否撤回了对 category 的同意
+func (s *MemoryConsentStore) IsOptedOut(distinctID string, category ConsentCategory) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	categories := s.optedOut[distinctID]
+	return categories[ConsentAll] || categories[category], nil
+}
+
+// OptOut 撤回对 categories 的同意，categories 为空时撤回所有用途
+func (s *MemoryConsentStore) OptOut(distinctID string, categories ...ConsentCategory) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.optOut(distinctID, categories)
+	return nil
+}
+
+// OptIn 恢复对 categories 的同意，categories 为空时恢复所有用途
+func (s *MemoryConsentStore) OptIn(distinctID string, categories ...ConsentCategory) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.optIn(distinctID, categories)
+	return nil
+}
+
+func (s *MemoryConsentStore) optOut(distinctID string, categories []ConsentCategory) {
+	if len(categories) == 0 {
+		categories = []ConsentCategory{ConsentAll}
+	}
+	if s.optedOut[distinctID] == nil {
+		s.optedOut[distinctID] = map[ConsentCategory]bool{}
+	}
+	for _, category := range categories {
+		s.optedOut[distinctID][category] = true
+	}
+}
+
+func (s *MemoryConsentStore) optIn(distinctID string, categories []ConsentCategory) {
+	if len(categories) == 0 {
+		delete(s.optedOut, distinctID)
+		return
+	}
+	for _, category := range categories {
+		delete(s.optedOut[distinctID], category)
+	}
+	if len(s.optedOut[distinctID]) == 0 {
+		delete(s.optedOut, distinctID)
+	}
+}
+
+// FileConsentStore 保存在 JSON 文件中的 ConsentStore，每次修改后立即写入文件
+type FileConsentStore struct {
+	MemoryConsentStore
+	path string
+}
+
+// NewFileConsentStore 创建新的 FileConsentStore，文件存在时从中读取已有记录
+// :param path: 文件路径
+func NewFileConsentStore(path string) (*FileConsentStore, error) {
+	s := &FileConsentStore{path: path}
+	s.optedOut = map[string]map[ConsentCategory]bool{}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records map[string][]ConsentCategory
+	if err := json.Unmarshal(b, &records); err != nil {
+		return nil, err
+	}
+	for distinctID, categories := range records {
+		s.optOut(distinctID, categories)
+	}
+	return s, nil
+}
+
+// OptOut 撤回对 categories 的同意，categories 为空时撤回所有用途
+func (s *FileConsentStore) OptOut(distinctID string, categories ...ConsentCategory) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.optOut(distinctID, categories)
+	return s.save()
+}
+
+// OptIn 恢复对 categories 的同意，categories 为空时恢复所有用途
+func (s *FileConsentStore) OptIn(distinctID string, categories ...ConsentCategory) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.optIn(distinctID, categories)
+	return s.save()
+}
+
+// save 先写入临时文件再替换，避免写入中断时损坏已有记录，调用时需持有锁
+func (s *FileConsentStore) save() error {
+	records := make(map[string][]ConsentCategory, len(s.optedOut))
+	for distinctID, categories := range s.optedOut {
+		for category := range categories {
+			records[distinctID] = append(records[distinctID], category)
+		}
+	}
+	b, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	return atomicfile.Write(s.path, b)
+}
+
+// messageDistinctID 返回已编码数据中的 distinct_id
+func messageDistinctID(s string) string {
+	var msg struct {
+		DistinctID string `json:"distinct_id"`
+	}
+	if err := json.Unmarshal([]byte(s), &msg); err != nil {
+		return ""
+	}
+	return msg.DistinctID
+}
+
+// purgeMessages 删除 distinct_id 为 distinctID 的数据，返回剩余数据及删除的条数
+func purgeMessages(msgs []string, distinctID string) ([]string, int) {
+	kept := msgs[:0]
+	purged := 0
+	for _, s := range msgs {
+		if messageDistinctID(s) == distinctID {
+			purged++
+			continue
+		}
+		kept = append(kept, s)
+	}
+	return kept, purged
+}
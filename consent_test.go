@@ -0,0 +1,73 @@
+package sensorsanalytics_test
+
+import (
+	"testing"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/satest"
+)
+
+func TestConsentSuppressesOptedOutUsers(t *testing.T) {
+	rec := satest.NewRecordingConsumer()
+	clt, _ := sa.NewClient(rec, "default", false)
+	store := sa.NewMemoryConsentStore()
+	clt.SetConsentStore(store, func(eventType string, eventName string) sa.ConsentCategory {
+		if eventName == "CampaignOpened" {
+			return sa.ConsentMarketing
+		}
+		return sa.ConsentAnalytics
+	})
+	store.OptOut("u1", sa.ConsentMarketing)
+	store.OptOut("u2")
+
+	clt.Track("u1", "CampaignOpened", nil, false)
+	clt.Track("u1", "OrderPaid", nil, false)
+	clt.Track("u2", "OrderPaid", nil, false)
+	clt.ProfileSet("u2", map[string]interface{}{"VIP": true}, false)
+
+	if got := rec.Envelopes(); len(got) != 1 || got[0].DistinctID != "u1" || got[0].Event != "OrderPaid" {
+		t.Errorf("sent %+v, want only u1 OrderPaid", got)
+	}
+	if n := clt.SuppressedCount(); n != 3 {
+		t.Errorf("SuppressedCount() = %d, want 3", n)
+	}
+}
+
+func TestForgetUser(t *testing.T) {
+	rec := satest.NewRecordingConsumer()
+	clt, _ := sa.NewClient(rec, "default", false)
+	if err := clt.SetRedactionPolicy(sa.RedactionPolicy{Rules: sa.PIIRules(sa.RedactHash), HashKey: []byte("k")}); err != nil {
+		t.Fatal(err)
+	}
+	store := sa.NewMemoryConsentStore()
+	clt.SetConsentStore(store, nil)
+
+	clt.Track("13812345678", "OrderPaid", nil, true)
+	clt.Track("u2", "OrderPaid", nil, false)
+	hashedID := rec.Envelopes()[0].DistinctID
+	store.OptOut("13812345678")
+
+	if err := clt.ForgetLoginUser("13812345678"); err != nil {
+		t.Fatal(err)
+	}
+	got := rec.Envelopes()
+	if len(got) != 2 || got[0].DistinctID != "u2" {
+		t.Fatalf("after ForgetLoginUser: %+v, want u2's event and the profile_delete", got)
+	}
+	deleted := got[1]
+	if deleted.Type != "profile_delete" || deleted.DistinctID != hashedID || deleted.Properties["$is_login_id"] != true {
+		t.Errorf("profile_delete: %+v, want hashed distinct_id %s with $is_login_id", deleted, hashedID)
+	}
+}
+
+func TestForgetUserAnonymous(t *testing.T) {
+	rec := satest.NewRecordingConsumer()
+	clt, _ := sa.NewClient(rec, "default", false)
+	if err := clt.ForgetUser("anon-1"); err != nil {
+		t.Fatal(err)
+	}
+	got := rec.OfType("profile_delete")
+	if len(got) != 1 || got[0].DistinctID != "anon-1" || got[0].Properties["$is_login_id"] == true {
+		t.Errorf("profile_delete: %+v, want anon-1 without $is_login_id", got)
+	}
+}
@@ -1,16 +1,23 @@
 package sensorsanalytics
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,15 +30,30 @@ type Consumer interface {
 
 // DefaultConsumer 默认的 Consumer实现，逐条、同步的发送数据给接收服务器。
 type DefaultConsumer struct {
-	urlPrefix string
+	transport
+	endpoints *EndpointSet
 	debug     bool
+	gzip      bool
+	breaker   *CircuitBreaker
+	fallback  Consumer
+	// fallbackOnError 为 true 时所有发送失败的数据都转交给 fallback，而不只是熔断器打开时
+	fallbackOnError bool
 }
 
 // NewDefaultConsumer 创建新的默认 Consumer
 // :param serverURL: 服务器的 URL 地址。
 func NewDefaultConsumer(serverURL string) (*DefaultConsumer, error) {
+	return NewDefaultConsumerWithEndpoints(newSingleEndpointSet(serverURL))
+}
+
+// NewDefaultConsumerWithEndpoints 创建新的默认 Consumer，发送失败时自动切换服务器地址
+// :param endpoints: 服务器的 URL 地址集合。
+func NewDefaultConsumerWithEndpoints(endpoints *EndpointSet) (*DefaultConsumer, error) {
 	var c DefaultConsumer
-	c.urlPrefix = serverURL
+	if endpoints == nil || endpoints.Len() == 0 {
+		return &c, errors.New("endpoints must not be empty")
+	}
+	c.endpoints = endpoints
 	return &c, nil
 }
 
@@ -40,39 +62,73 @@ func (c *DefaultConsumer) SetDebug(debug bool) {
 	c.debug = debug
 }
 
+// SetGzip 批量发送时是否使用 gzip 压缩 data_list
+func (c *DefaultConsumer) SetGzip(gzip bool) {
+	c.gzip = gzip
+}
+
+// SetCircuitBreaker 设置熔断器，熔断器打开时发送直接返回 ErrCircuitOpen 或转交给 fallback Consumer
+func (c *DefaultConsumer) SetCircuitBreaker(breaker *CircuitBreaker) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.breaker = breaker
+}
+
+// SetFallback 设置熔断器打开时接收数据的 Consumer，例如将数据写入本地文件
+func (c *DefaultConsumer) SetFallback(fallback Consumer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.fallback = fallback
+}
+
+// SetFallbackOnError 为 true 时，除熔断器打开外，网络错误、服务器返回错误等任何发送失败的数据也都转交给 fallback，
+// 适合将 fallback 作为本地暂存、保证数据不丢失的场景
+func (c *DefaultConsumer) SetFallbackOnError(enabled bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.fallbackOnError = enabled
+}
+
+func (c *DefaultConsumer) breakerAndFallback() (*CircuitBreaker, Consumer) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.breaker, c.fallback
+}
+
+// shouldDivert 发送失败的数据是否转交给 fallback
+func (c *DefaultConsumer) shouldDivert(err error, fallback Consumer) bool {
+	if err == nil || fallback == nil {
+		return false
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.fallbackOnError || errors.Is(err, ErrCircuitOpen)
+}
+
 // Send 发送数据
 func (c *DefaultConsumer) Send(msg map[string]interface{}) error {
 	data, s, err := c.encodeMsg(msg)
 	if err != nil {
 		return fmt.Errorf("%s: %s", ErrIllegalDataException, err)
 	}
-	req, err := http.NewRequest("GET", c.urlPrefix, nil)
-	q := req.URL.Query()
-	q.Add("data", data)
-	req.URL.RawQuery = q.Encode()
-	if err != nil {
-		return fmt.Errorf("%s: %s", ErrNetworkException, err)
-	}
-	req.Header.Set("Content-Type", "application/json; charset=utf-8")
-	var clt http.Client
-	resp, err := clt.Do(req)
-	if err != nil {
-		return fmt.Errorf("%s: %s", ErrNetworkException, err)
+	breaker, fallback := c.breakerAndFallback()
+	err = c.deliver(breaker, func(serverURL string) error {
+		return c.sendData(serverURL, data, s, c.debug)
+	})
+	if c.shouldDivert(err, fallback) {
+		return fallback.Send(msg)
 	}
-	defer resp.Body.Close()
-	if c.debug {
-		log.Printf("message: %s", string(s))
-		log.Printf("ret_code: %d", resp.StatusCode)
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("read response body: %s", err)
-		}
-		log.Printf("resp content: %s", string(body))
-	}
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("%s: %s", ErrNetworkException, fmt.Sprintf("Error response status code [code=%d]", resp.StatusCode))
+	return err
+}
+
+// deliver 经过熔断器将请求发送到可用的服务器地址
+func (c *DefaultConsumer) deliver(breaker *CircuitBreaker, fn func(serverURL string) error) error {
+	if breaker == nil {
+		return c.endpoints.send(fn)
 	}
-	return nil
+	return breaker.Do(func() error {
+		return c.endpoints.send(fn)
+	})
 }
 
 // Flush flush data
@@ -94,28 +150,156 @@ func (c *DefaultConsumer) encodeMsg(msg map[string]interface{}) (string, string,
 	return data, string(s), nil
 }
 
+// SendEncoded 立即发送一批已编码为 JSON 的数据，不检查格式，用于转发日志文件等场景
+func (c *DefaultConsumer) SendEncoded(msgList []string) error {
+	if len(msgList) == 0 {
+		return nil
+	}
+	return c.sendMsgList(msgList)
+}
+
+// sendMsgList 将一批数据发送到可用的服务器地址
+func (c *DefaultConsumer) sendMsgList(msgList []string) error {
+	_, err := c.deliverMsgList(msgList)
+	return err
+}
+
+// deliverMsgList 将一批数据发送到可用的服务器地址，熔断器打开 (或设置了 SetFallbackOnError 时发送失败) 时
+// 转交给 fallback Consumer，返回是否进行了转交
+func (c *DefaultConsumer) deliverMsgList(msgList []string) (bool, error) {
+	dataList, s := c.encodeMsgList(msgList)
+	breaker, fallback := c.breakerAndFallback()
+	err := c.deliver(breaker, func(serverURL string) error {
+		return c.sendDataList(serverURL, dataList, c.gzip, s, c.debug)
+	})
+	if c.shouldDivert(err, fallback) {
+		return true, divert(fallback, msgList)
+	}
+	return false, err
+}
+
+// divert 将已编码的数据解码后逐条转交给 fallback Consumer，返回第一个错误
+func divert(fallback Consumer, msgList []string) error {
+	var firstErr error
+	for _, s := range msgList {
+		var msg map[string]interface{}
+		decoder := json.NewDecoder(strings.NewReader(s))
+		decoder.UseNumber()
+		err := decoder.Decode(&msg)
+		if err == nil {
+			err = fallback.Send(msg)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 func (c *DefaultConsumer) encodeMsgList(msgList []string) (string, string) {
 	s := fmt.Sprintf("[%s]", strings.Join(msgList, ","))
-	return base64.StdEncoding.EncodeToString([]byte(s)), s
+	if !c.gzip {
+		return base64.StdEncoding.EncodeToString([]byte(s)), s
+	}
+	// 写入 bytes.Buffer 不会失败
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	zw.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), s
+}
+
+// messageBatch 一批待发送的数据，同时记录拼接为 data_list 后的原始字节数
+type messageBatch struct {
+	messages []string
+	size     int
+}
+
+func (b *messageBatch) len() int {
+	return len(b.messages)
+}
+
+func (b *messageBatch) add(msg string) {
+	b.messages = append(b.messages, msg)
+	// 每条数据额外占用一个 `[` 或 `,`
+	b.size += len(msg) + 1
+}
+
+// purge 删除指定用户的数据，返回删除的条数
+func (b *messageBatch) purge(distinctID string) int {
+	kept, purged := purgeMessages(b.messages, distinctID)
+	b.reset()
+	for _, msg := range kept {
+		b.add(msg)
+	}
+	return purged
+}
+
+func (b *messageBatch) reset() {
+	b.messages = nil
+	b.size = 0
+}
+
+// encodedSize 返回当前数据编码为 data_list 后的字节数
+func (b *messageBatch) encodedSize() int {
+	if len(b.messages) == 0 {
+		return 0
+	}
+	return base64.StdEncoding.EncodedLen(b.size + 1)
+}
+
+// overflows 判断加入 msg 后编码大小是否超过 maxBytes，空的 batch 总是可以加入
+func (b *messageBatch) overflows(msg string, maxBytes int) bool {
+	if maxBytes <= 0 || len(b.messages) == 0 {
+		return false
+	}
+	return base64.StdEncoding.EncodedLen(b.size+len(msg)+2) > maxBytes
+}
+
+// full 判断数据条数或编码大小是否已达到上限
+func (b *messageBatch) full(maxSize int, maxBytes int) bool {
+	if len(b.messages) >= maxSize {
+		return true
+	}
+	return maxBytes > 0 && b.encodedSize() >= maxBytes
 }
 
 // BatchConsumer  批量发送数据的 Consumer，当且仅当数据达到 buffer_size 参数指定的量时，才将数据进行发送。
 type BatchConsumer struct {
 	DefaultConsumer
-	maxBatchSize int
-	batchBuffer  []string
+	maxBatchSize  int
+	maxBatchBytes int
+	batch         messageBatch
 }
 
 // NewBatchConsumer 创建新的 batch consumer
 func NewBatchConsumer(serverURL string, maxBatchSize int) (*BatchConsumer, error) {
+	if maxBatchSize <= 0 || maxBatchSize > DefaultMaxBatchSize {
+		maxBatchSize = DefaultMaxBatchSize
+	}
+	return NewBatchConsumerWithConfig(serverURL, BatchConfig{MaxBatchSize: maxBatchSize})
+}
+
+// NewBatchConsumerWithConfig 使用指定配置创建新的 batch consumer，数据条数或编码后大小任一达到上限时进行发送
+// :param serverURL: 服务器 URL 地址
+// :param config: 批量发送配置
+func NewBatchConsumerWithConfig(serverURL string, config BatchConfig) (*BatchConsumer, error) {
+	return NewBatchConsumerWithEndpoints(newSingleEndpointSet(serverURL), config)
+}
+
+// NewBatchConsumerWithEndpoints 使用指定配置创建新的 batch consumer，发送失败时自动切换服务器地址
+// :param endpoints: 服务器的 URL 地址集合
+// :param config: 批量发送配置
+func NewBatchConsumerWithEndpoints(endpoints *EndpointSet, config BatchConfig) (*BatchConsumer, error) {
 	var c BatchConsumer
-	c.urlPrefix = serverURL
-	if maxBatchSize > 0 && maxBatchSize <= 50 {
-		c.maxBatchSize = maxBatchSize
-	} else {
-		c.maxBatchSize = 50
+	if endpoints == nil || endpoints.Len() == 0 {
+		return &c, errors.New("endpoints must not be empty")
 	}
-	c.batchBuffer = []string{}
+	config = config.withDefaults()
+	c.endpoints = endpoints
+	c.maxBatchSize = config.MaxBatchSize
+	c.maxBatchBytes = config.MaxBatchBytes
+	c.gzip = config.Gzip
 	return &c, nil
 }
 
@@ -125,63 +309,99 @@ func (c *BatchConsumer) Send(msg map[string]interface{}) error {
 	if err != nil {
 		return fmt.Errorf("%s: %s", ErrIllegalDataException, err)
 	}
-	c.batchBuffer = append(c.batchBuffer, string(s))
-	if len(c.batchBuffer) >= c.maxBatchSize {
+	if c.batch.overflows(s, c.maxBatchBytes) {
+		if err := c.Flush(); err != nil {
+			return err
+		}
+	}
+	c.batch.add(s)
+	if c.batch.full(c.maxBatchSize, c.maxBatchBytes) {
 		return c.Flush()
 	}
 	return nil
 }
 
+// Fits 判断已编码的 msg 加入 pending 后是否仍不超过 MaxBatchSize 及 MaxBatchBytes，空的 pending 总是可以加入。
+// 自行攒批并通过 SendEncoded 发送时，用于与 Send 使用相同的分批规则
+// :param pending: 当前批次中已编码的数据
+// :param msg: 待加入的已编码数据
+func (c *BatchConsumer) Fits(pending []string, msg string) bool {
+	if len(pending) >= c.maxBatchSize {
+		return false
+	}
+	var b messageBatch
+	for _, m := range pending {
+		b.add(m)
+	}
+	return !b.overflows(msg, c.maxBatchBytes)
+}
+
 // Flush  用户可以主动调用 flush 接口，以便在需要的时候立即进行数据发送。
 func (c *BatchConsumer) Flush() error {
-	if len(c.batchBuffer) > 0 {
-		dataList, s := c.encodeMsgList(c.batchBuffer)
-		q := url.Values{}
-		q.Add("data_list", dataList)
-		req, err := http.NewRequest("POST", c.urlPrefix, strings.NewReader(q.Encode()))
-		if err != nil {
-			return fmt.Errorf("%s: %s", ErrNetworkException, err)
-		}
-		req.Header.Set("Content-Type", "application/json; charset=utf-8")
-		var clt http.Client
-		resp, err := clt.Do(req)
-		if err != nil {
-			return fmt.Errorf("%s: %s", ErrNetworkException, err)
-		}
-		defer resp.Body.Close()
-		if c.debug {
-			log.Printf("message: %s", string(s))
-			log.Printf("ret_code: %d", resp.StatusCode)
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				log.Printf("read response body: %s", err)
-			}
-			log.Printf("resp content: %s", string(body))
-		}
-		if resp.StatusCode != 200 {
-			return fmt.Errorf("%s: %s", ErrNetworkException, fmt.Sprintf("Error response status code [code=%d]", resp.StatusCode))
+	if c.batch.len() > 0 {
+		if err := c.sendMsgList(c.batch.messages); err != nil {
+			return err
 		}
-		c.batchBuffer = []string{}
+		c.batch.reset()
 	}
 	return nil
 }
 
+// Purge 删除指定用户尚未发送的数据
+func (c *BatchConsumer) Purge(distinctID string) int {
+	return c.batch.purge(distinctID)
+}
+
 // Close 在发送完成时，调用此接口以保证数据发送完成。
 func (c *BatchConsumer) Close() error {
 	return c.Flush()
 }
 
 // AsyncBatchConsumer 异步、批量发送数据的 Consumer。使用独立的线程进行数据发送，当满足以下两个条件之一时进行数据发送:
+// 1. 缓冲区中的数据条数或编码后大小达到上限
+// 2. 距离上次发送的时间达到 flushInterval
+// 攒满的数据会被封装为 batch 放入发送队列，由 workers 个发送线程并行发送。
 type AsyncBatchConsumer struct {
 	DefaultConsumer
-	lock          sync.Mutex
+	lock          sync.RWMutex
 	wg            sync.WaitGroup
 	maxBatchSize  int
+	maxBatchBytes int
 	bufferSize    int
+	flushInterval time.Duration
+	workers       int
+	queueSize     int
 	senderRunning bool
-	batchBuffer   []string
+	batch         messageBatch
 	sendCh        chan string
-	stopCh        chan bool
+	requestCh     chan func()
+	batchCh       chan []string
+	stats         AsyncBatchStats
+}
+
+// AsyncBatchStats AsyncBatchConsumer 的发送统计
+type AsyncBatchStats struct {
+	// Received Send 接收的数据条数
+	Received int64
+	// Dropped 因 Consumer 已关闭而被拒绝的数据条数
+	Dropped int64
+	// Sent 发送成功的数据条数
+	Sent int64
+	// Failed 发送失败的数据条数
+	Failed int64
+	// Diverted 熔断器打开或发送失败时转交给 fallback Consumer 的数据条数
+	Diverted int64
+	// Purged 发送前被 Purge 删除的数据条数
+	Purged int64
+	// Batches 发送成功的请求数
+	Batches int64
+	// FailedBatches 发送失败的请求数
+	FailedBatches int64
+}
+
+// Pending 已接收但尚未完成发送的数据条数
+func (s AsyncBatchStats) Pending() int64 {
+	return s.Received - s.Sent - s.Failed - s.Diverted - s.Purged
 }
 
 // NewAsyncBatchConsumer 创建新的 AsyncBatchConsumer
@@ -189,85 +409,132 @@ type AsyncBatchConsumer struct {
 // :param maxBatchSize 单个请求发送的最大大小
 // :param bufferSize 接收数据缓冲区大小
 func NewAsyncBatchConsumer(serverURL string, maxBatchSize int, bufferSize int) (*AsyncBatchConsumer, error) {
-	var c AsyncBatchConsumer
-	c.urlPrefix = serverURL
-	c.maxBatchSize = maxBatchSize
-	if maxBatchSize > 0 || maxBatchSize < 50 {
-		c.maxBatchSize = maxBatchSize
-	} else {
-		c.maxBatchSize = 50
+	if maxBatchSize <= 0 || maxBatchSize > DefaultMaxBatchSize {
+		maxBatchSize = DefaultMaxBatchSize
 	}
-	if bufferSize > 0 || bufferSize < 1000 {
+	return NewAsyncBatchConsumerWithConfig(serverURL, BatchConfig{
+		MaxBatchSize: maxBatchSize,
+		BufferSize:   bufferSize,
+	})
+}
+
+// NewAsyncBatchConsumerWithConfig 使用指定配置创建新的 AsyncBatchConsumer
+// :param serverURL: 服务器 URL 地址
+// :param config: 批量发送配置
+func NewAsyncBatchConsumerWithConfig(serverURL string, config BatchConfig) (*AsyncBatchConsumer, error) {
+	return NewAsyncBatchConsumerWithEndpoints(newSingleEndpointSet(serverURL), config)
+}
 
+// NewAsyncBatchConsumerWithEndpoints 使用指定配置创建新的 AsyncBatchConsumer，发送失败时自动切换服务器地址
+// :param endpoints: 服务器的 URL 地址集合
+// :param config: 批量发送配置
+func NewAsyncBatchConsumerWithEndpoints(endpoints *EndpointSet, config BatchConfig) (*AsyncBatchConsumer, error) {
+	var c AsyncBatchConsumer
+	if endpoints == nil || endpoints.Len() == 0 {
+		return &c, errors.New("endpoints must not be empty")
 	}
-	c.bufferSize = bufferSize
-	c.batchBuffer = []string{}
-	c.stopCh = make(chan bool, 1)
+	config = config.withDefaults()
+	c.endpoints = endpoints
+	c.maxBatchSize = config.MaxBatchSize
+	c.maxBatchBytes = config.MaxBatchBytes
+	c.gzip = config.Gzip
+	c.bufferSize = config.BufferSize
+	c.flushInterval = config.FlushInterval
+	c.workers = config.Workers
+	c.queueSize = config.QueueSize
 	err := c.Run()
 	return &c, err
 }
 
-// Run 运行 Seeder
+// Run 运行 Sender 及发送线程
 func (c *AsyncBatchConsumer) Run() error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	if c.senderRunning {
-		return errors.New("")
+		return errors.New("AsyncBatchConsumer sender is already running")
 	}
+	c.sendCh = make(chan string, c.bufferSize)
+	c.requestCh = make(chan func())
+	c.batchCh = make(chan []string, c.queueSize)
+	c.wg.Add(1 + c.workers)
 	go c.runSender()
+	for i := 0; i < c.workers; i++ {
+		go c.runWorker()
+	}
 	c.senderRunning = true
 	return nil
 }
 
+// runSender 将接收到的数据攒成 batch 放入发送队列，sendCh 关闭后封装剩余数据并关闭发送队列
 func (c *AsyncBatchConsumer) runSender() {
-	c.sendCh = make(chan string, c.bufferSize)
-	ticker := time.NewTicker(30 * time.Second)
-	c.wg.Add(1)
 	defer c.wg.Done()
-ForLoop:
+	defer close(c.batchCh)
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
 	for {
 		select {
 		case data, ok := <-c.sendCh:
-			if ok {
-				c.batchBuffer = append(c.batchBuffer, data)
+			if !ok {
+				c.seal()
+				return
 			}
-			if len(c.batchBuffer) >= c.maxBatchSize {
-				err := c.Flush()
-				if err != nil {
-					log.Printf("AsyncBatchConsumer Flush Data: %s", err)
-				}
+			if c.appendMessage(data) {
+				// 因数据量触发发送后重新计时，避免紧接着再发送一个很小的 batch
+				ticker.Reset(c.flushInterval)
 			}
+		case fn := <-c.requestCh:
+			fn()
 		case <-ticker.C:
-			err := c.Flush()
-			if err != nil {
-				log.Printf("AsyncBatchConsumer Flush Data: %s", err)
-			}
-		case <-c.stopCh:
-			close(c.sendCh)
-			for data := range c.sendCh {
-				c.batchBuffer = append(c.batchBuffer, data)
-				if len(c.batchBuffer) >= c.maxBatchSize {
-					err := c.Flush()
-					if err != nil {
-						log.Printf("AsyncBatchConsumer Flush Data: %s", err)
-					}
-				}
-			}
-			err := c.Flush()
-			if err != nil {
-				log.Printf("AsyncBatchConsumer Flush Data: %s", err)
-			}
-			if c.senderRunning {
-				c.senderRunning = false
-				break ForLoop
-			}
+			c.seal()
 		}
 	}
 }
 
-// Stop  停止 Sender
+// runWorker 从发送队列中取出 batch 进行发送，直到队列关闭
+func (c *AsyncBatchConsumer) runWorker() {
+	defer c.wg.Done()
+	for msgs := range c.batchCh {
+		if err := c.sendBatch(msgs); err != nil {
+			log.Printf("AsyncBatchConsumer Flush Data: %s", err)
+		}
+	}
+}
+
+// appendMessage 将数据加入缓冲区，数据条数或大小达到上限时放入发送队列，返回是否进行了发送
+func (c *AsyncBatchConsumer) appendMessage(data string) bool {
+	flushed := false
+	if c.batch.overflows(data, c.maxBatchBytes) {
+		c.seal()
+		flushed = true
+	}
+	c.batch.add(data)
+	if c.batch.full(c.maxBatchSize, c.maxBatchBytes) {
+		c.seal()
+		flushed = true
+	}
+	return flushed
+}
+
+// seal 将缓冲区中的数据作为一个 batch 放入发送队列
+func (c *AsyncBatchConsumer) seal() {
+	if c.batch.len() == 0 {
+		return
+	}
+	msgs := c.batch.messages
+	c.batch.reset()
+	c.batchCh <- msgs
+}
+
+// Stop  停止 Sender，等待所有已接收的数据发送完成
 func (c *AsyncBatchConsumer) Stop() error {
-	c.stopCh <- true
+	c.lock.Lock()
+	if !c.senderRunning {
+		c.lock.Unlock()
+		return nil
+	}
+	c.senderRunning = false
+	close(c.sendCh)
+	c.lock.Unlock()
 	c.wg.Wait()
 	return nil
 }
@@ -278,75 +545,120 @@ func (c *AsyncBatchConsumer) Send(msg map[string]interface{}) error {
 	if err != nil {
 		return fmt.Errorf("%s: %s", ErrIllegalDataException, err)
 	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if !c.senderRunning {
+		atomic.AddInt64(&c.stats.Dropped, 1)
+		return ErrConsumerClosed
+	}
+	atomic.AddInt64(&c.stats.Received, 1)
 	c.sendCh <- string(s)
 	return nil
 }
 
 // Flush  用户可以主动调用 flush 接口，以便在需要的时候立即进行数据发送。
+// 缓冲区中的数据会被放入发送队列，由发送线程异步发送。Consumer 已关闭时返回 ErrConsumerClosed。
 func (c *AsyncBatchConsumer) Flush() error {
-	if len(c.batchBuffer) > 0 {
-		dataList, s := c.encodeMsgList(c.batchBuffer)
-		req, err := http.NewRequest("GET", c.urlPrefix, nil)
-		q := req.URL.Query()
-		q.Add("data_list", dataList)
-		req.URL.RawQuery = q.Encode()
-		if err != nil {
-			return fmt.Errorf("%s: %s", ErrNetworkException, err)
-		}
-		req.Header.Set("Content-Type", "application/json; charset=utf-8")
-		var clt http.Client
-		resp, err := clt.Do(req)
-		if err != nil {
-			log.Printf("%s: %s", ErrNetworkException, err)
-		}
-		if c.debug {
-			log.Printf("message: %s", string(s))
-			log.Printf("ret_code: %d", resp.StatusCode)
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				log.Printf("read response body: %s", err)
-			}
-			log.Printf("resp content: %s", string(body))
-		}
-		if resp.StatusCode != 200 {
-			log.Printf("%s: %s", ErrNetworkException, fmt.Sprintf("Error response status code [code=%d]", resp.StatusCode))
-		}
-		c.batchBuffer = []string{}
+	if !c.request(c.seal) {
+		return ErrConsumerClosed
 	}
 	return nil
 }
 
 // SyncFlush  执行一次同步发送。 表示在发送失败时抛出错误。
+// 只同步发送缓冲区中尚未放入发送队列的数据。Consumer 已关闭时返回 ErrConsumerClosed。
 func (c *AsyncBatchConsumer) SyncFlush() error {
-	if len(c.batchBuffer) > 0 {
-		dataList, s := c.encodeMsgList(c.batchBuffer)
-		req, err := http.NewRequest("GET", c.urlPrefix, nil)
-		q := req.URL.Query()
-		q.Add("data_list", dataList)
-		req.URL.RawQuery = q.Encode()
-		if err != nil {
-			return fmt.Errorf("%s: %s", ErrNetworkException, err)
-		}
-		req.Header.Set("Content-Type", "application/json; charset=utf-8")
-		var clt http.Client
-		resp, err := clt.Do(req)
-		if err != nil {
-			return fmt.Errorf("%s: %s", ErrNetworkException, err)
+	var msgs []string
+	running := c.request(func() {
+		msgs = c.batch.messages
+		c.batch.reset()
+	})
+	if !running {
+		return ErrConsumerClosed
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return c.sendBatch(msgs)
+}
+
+// Purge 删除指定用户尚未发送的数据，包括接收缓冲区、当前 batch 及发送队列中的数据，
+// 发送线程正在发送的数据无法删除
+func (c *AsyncBatchConsumer) Purge(distinctID string) int {
+	purged := 0
+	c.request(func() {
+		// 先处理接收缓冲区中已有的数据
+		for n := len(c.sendCh); n > 0; n-- {
+			c.appendMessage(<-c.sendCh)
 		}
-		if c.debug {
-			log.Printf("message: %s", string(s))
-			log.Printf("ret_code: %d", resp.StatusCode)
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				log.Printf("read response body: %s", err)
+		purged = c.batch.purge(distinctID)
+		var queued [][]string
+	Drain:
+		for {
+			select {
+			case msgs := <-c.batchCh:
+				queued = append(queued, msgs)
+			default:
+				break Drain
 			}
-			log.Printf("resp content: %s", string(body))
 		}
-		if resp.StatusCode != 200 {
-			return fmt.Errorf("%s: %s", ErrNetworkException, fmt.Sprintf("Error response status code [code=%d]", resp.StatusCode))
+		// sender 是发送队列唯一的写入方，取出的 batch 一定可以放回
+		for _, msgs := range queued {
+			msgs, n := purgeMessages(msgs, distinctID)
+			purged += n
+			if len(msgs) > 0 {
+				c.batchCh <- msgs
+			}
 		}
-		c.batchBuffer = make([]string, c.maxBatchSize)
+	})
+	atomic.AddInt64(&c.stats.Purged, int64(purged))
+	return purged
+}
+
+// request 在 sender 线程中执行 fn 并等待其完成，Consumer 已关闭时不执行并返回 false
+func (c *AsyncBatchConsumer) request(fn func()) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if !c.senderRunning {
+		return false
+	}
+	done := make(chan struct{})
+	c.requestCh <- func() {
+		fn()
+		close(done)
+	}
+	<-done
+	return true
+}
+
+// Stats 返回当前的发送统计
+func (c *AsyncBatchConsumer) Stats() AsyncBatchStats {
+	return AsyncBatchStats{
+		Received:      atomic.LoadInt64(&c.stats.Received),
+		Dropped:       atomic.LoadInt64(&c.stats.Dropped),
+		Sent:          atomic.LoadInt64(&c.stats.Sent),
+		Failed:        atomic.LoadInt64(&c.stats.Failed),
+		Diverted:      atomic.LoadInt64(&c.stats.Diverted),
+		Purged:        atomic.LoadInt64(&c.stats.Purged),
+		Batches:       atomic.LoadInt64(&c.stats.Batches),
+		FailedBatches: atomic.LoadInt64(&c.stats.FailedBatches),
 	}
+}
+
+// sendBatch 发送一个 batch 并记录发送统计
+func (c *AsyncBatchConsumer) sendBatch(msgs []string) error {
+	diverted, err := c.deliverMsgList(msgs)
+	if err != nil {
+		atomic.AddInt64(&c.stats.Failed, int64(len(msgs)))
+		atomic.AddInt64(&c.stats.FailedBatches, 1)
+		return err
+	}
+	if diverted {
+		atomic.AddInt64(&c.stats.Diverted, int64(len(msgs)))
+		return nil
+	}
+	atomic.AddInt64(&c.stats.Sent, int64(len(msgs)))
+	atomic.AddInt64(&c.stats.Batches, 1)
 	return nil
 }
 
@@ -355,24 +667,87 @@ func (c *AsyncBatchConsumer) Close() error {
 	return c.Stop()
 }
 
-// ConsoleConsumer 将数据直接输出到标准输出
+// ConsoleFormat ConsoleConsumer 的输出格式
+type ConsoleFormat int
+
+const (
+	// ConsolePretty 缩进的 JSON，每条数据占多行
+	ConsolePretty ConsoleFormat = iota
+	// ConsoleJSONL 紧凑的 JSON，每条数据占一行，适合日志收集
+	ConsoleJSONL
+	// ConsoleSummary 便于阅读的单行摘要，例如 "track OrderPaid user=123 amount=10"
+	ConsoleSummary
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiDim    = "\x1b[2m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiBlue   = "\x1b[34m"
+	ansiCyan   = "\x1b[36m"
+)
+
+// ConsoleConsumer 将数据输出到标准输出或指定的 io.Writer，可以并发使用。
+// 零值以 ConsolePretty 格式输出到标准输出。
 type ConsoleConsumer struct {
+	lock   sync.Mutex
+	writer io.Writer
+	format ConsoleFormat
+	color  bool
 }
 
-// NewConsoleConsumer 创建新的 ConsoleConsumer
+// NewConsoleConsumer 创建新的 ConsoleConsumer，以 ConsolePretty 格式输出到标准输出
 func NewConsoleConsumer() *ConsoleConsumer {
-	var c ConsoleConsumer
-	return &c
+	return NewConsoleConsumerWithWriter(os.Stdout, ConsolePretty)
 }
 
-// Send 发送数据
+// NewConsoleConsumerWithWriter 创建输出到 writer 的 ConsoleConsumer
+// :param writer: 输出的位置，例如文件或测试中的 bytes.Buffer
+// :param format: 输出格式
+func NewConsoleConsumerWithWriter(writer io.Writer, format ConsoleFormat) *ConsoleConsumer {
+	return &ConsoleConsumer{writer: writer, format: format}
+}
+
+// SetColor 是否使用 ANSI 颜色，只对 ConsolePretty 及 ConsoleSummary 生效，ConsoleJSONL 始终不使用颜色
+func (c *ConsoleConsumer) SetColor(color bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.color = color
+}
+
+// Send 输出数据
 func (c *ConsoleConsumer) Send(msg map[string]interface{}) error {
-	b, err := json.MarshalIndent(msg, "", "    ")
-	if err != nil {
-		return err
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var line string
+	switch c.format {
+	case ConsoleJSONL:
+		b, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		line = string(b)
+	case ConsoleSummary:
+		line = summarizeMessage(msg, c.color)
+	default:
+		b, err := json.MarshalIndent(msg, "", "    ")
+		if err != nil {
+			return err
+		}
+		line = string(b)
+		if c.color {
+			line = typeColor(msg) + line + ansiReset
+		}
 	}
-	fmt.Println(string(b))
-	return nil
+	writer := c.writer
+	if writer == nil {
+		// 零值的 ConsoleConsumer 与 NewConsoleConsumer 相同，输出到标准输出
+		writer = os.Stdout
+	}
+	_, err := io.WriteString(writer, line+"\n")
+	return err
 }
 
 // Flush  用户可以主动调用 flush 接口，以便在需要的时候立即进行数据发送。
@@ -385,11 +760,89 @@ func (c *ConsoleConsumer) Close() error {
 	return nil
 }
 
+// summarizeMessage 生成 "类型 事件名 user=distinct_id key=value..." 格式的摘要，
+// 以 $ 开头的预置属性不输出
+func summarizeMessage(msg map[string]interface{}, color bool) string {
+	msgType, _ := msg["type"].(string)
+	event, _ := msg["event"].(string)
+	distinctID, _ := msg["distinct_id"].(string)
+
+	var b strings.Builder
+	if color {
+		b.WriteString(typeColor(msg) + msgType + ansiReset)
+	} else {
+		b.WriteString(msgType)
+	}
+	if event != "" {
+		b.WriteByte(' ')
+		if color {
+			b.WriteString(ansiBold + event + ansiReset)
+		} else {
+			b.WriteString(event)
+		}
+	}
+	writeField := func(key string, value interface{}) {
+		b.WriteByte(' ')
+		if color {
+			b.WriteString(ansiDim + key + "=" + ansiReset)
+		} else {
+			b.WriteString(key + "=")
+		}
+		b.WriteString(summaryValue(value))
+	}
+	writeField("user", distinctID)
+	if originalID, ok := msg["original_id"].(string); ok {
+		writeField("original", originalID)
+	}
+	properties, _ := msg["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(properties))
+	for k := range properties {
+		if !strings.HasPrefix(k, "$") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeField(k, properties[k])
+	}
+	return b.String()
+}
+
+func summaryValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			return strconv.Quote(v)
+		}
+		return v
+	case []string:
+		return "[" + strings.Join(v, ",") + "]"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func typeColor(msg map[string]interface{}) string {
+	msgType, _ := msg["type"].(string)
+	switch {
+	case msgType == "track":
+		return ansiGreen
+	case msgType == "track_signup":
+		return ansiCyan
+	case strings.HasPrefix(msgType, "profile_"):
+		return ansiBlue
+	default:
+		return ansiYellow
+	}
+}
+
 // DebugConsumer 调试用的 Consumer，逐条发送数据到服务器的Debug API,并且等待服务器返回的结果
 // 具体的说明在http://www.sensorsdata.cn/manual/
 type DebugConsumer struct {
+	transport
 	urlPrefix      string
 	debugWriteData bool
+	verbose        bool
 }
 
 // NewDebugConsumer 创建新的调试 consumer
@@ -405,42 +858,49 @@ func NewDebugConsumer(serverURL string, writeData bool) (*DebugConsumer, error)
 	return &c, err
 }
 
-// Send 发送数据
+// SetVerbose 为 true 时记录发送成功的数据，默认只返回错误不记录日志
+func (c *DebugConsumer) SetVerbose(verbose bool) {
+	c.verbose = verbose
+}
+
+// Send 发送数据，服务器拒绝数据时返回 *DebugError
 func (c *DebugConsumer) Send(msg map[string]interface{}) error {
 	data, s, err := c.encodeMsg(msg)
 	if err != nil {
 		return fmt.Errorf("%s: %s", ErrIllegalDataException, err)
 	}
 	req, err := http.NewRequest("GET", c.urlPrefix, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNetworkException, err)
+	}
 	q := req.URL.Query()
 	q.Add("data", data)
 	req.URL.RawQuery = q.Encode()
-	if err != nil {
-		return fmt.Errorf("%s: %s", ErrNetworkException, err)
-	}
 	if !c.debugWriteData {
 		req.Header.Add("Dry-Run", "true")
 	}
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
-	var clt http.Client
-	resp, err := clt.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
-		log.Printf("%s: %s", ErrNetworkException, err)
-		return fmt.Errorf("%s: %s", ErrNetworkException, err)
+		return err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode == 200 {
-		log.Printf("%s", s)
-	} else {
-		log.Printf("invalid message: %s", string(data))
-		log.Printf("ret_code: %d", resp.StatusCode)
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			log.Printf("read response body: %s", err)
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNetworkException, err)
+	}
+	switch {
+	case resp.StatusCode == 200:
+		if c.verbose {
+			log.Printf("%s", s)
 		}
-		log.Printf("resp content: %s", string(body))
+		return nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		// 服务器故障，与数据内容无关
+		return &StatusError{StatusCode: resp.StatusCode}
+	default:
+		return parseDebugError(resp.StatusCode, body)
 	}
-	return nil
 }
 
 // Flush  用户可以主动调用 flush 接口，以便在需要的时候立即进行数据发送。
@@ -0,0 +1,180 @@
+package sensorsanalytics_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+func TestAsyncBatchConsumerClosed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	consumer, err := sa.NewAsyncBatchConsumerWithConfig(srv.URL, sa.BatchConfig{Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := consumer.Send(map[string]interface{}{"distinct_id": "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := consumer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := consumer.Stats(); stats.Sent != 1 || stats.Pending() != 0 {
+		t.Errorf("stats after Close: %+v", stats)
+	}
+	for name, fn := range map[string]func() error{
+		"Send":      func() error { return consumer.Send(map[string]interface{}{"distinct_id": "u1"}) },
+		"Flush":     consumer.Flush,
+		"SyncFlush": consumer.SyncFlush,
+	} {
+		if err := fn(); !errors.Is(err, sa.ErrConsumerClosed) {
+			t.Errorf("%s after Close: got %v, want ErrConsumerClosed", name, err)
+		}
+	}
+}
+
+func TestAsyncBatchConsumerWorkers(t *testing.T) {
+	tests := []struct {
+		workers  int
+		failNext int
+	}{
+		{1, 0},
+		{4, 0},
+		{4, 2},
+		{8, 5},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("workers=%d,fail=%d", tt.workers, tt.failNext), func(t *testing.T) {
+			c := newTestCollector()
+			defer c.Close()
+			c.latency = time.Millisecond
+			c.failNext, c.failStatus = tt.failNext, http.StatusInternalServerError
+			consumer, err := sa.NewAsyncBatchConsumerWithConfig(c.URL, sa.BatchConfig{
+				MaxBatchSize: 10,
+				Workers:      tt.workers,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 200; i++ {
+				if err := consumer.Send(trackMsg(fmt.Sprintf("u%d", i), nil)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := consumer.Close(); err != nil {
+				t.Fatal(err)
+			}
+			want := sa.AsyncBatchStats{
+				Received:      200,
+				Sent:          int64(200 - 10*tt.failNext),
+				Failed:        int64(10 * tt.failNext),
+				Batches:       int64(20 - tt.failNext),
+				FailedBatches: int64(tt.failNext),
+			}
+			if stats := consumer.Stats(); stats != want {
+				t.Errorf("stats %+v, want %+v", stats, want)
+			}
+			if got := c.receivedCount(); int64(got) != want.Sent {
+				t.Errorf("collector got %d messages, want %d", got, want.Sent)
+			}
+		})
+	}
+}
+
+// BenchmarkAsyncBatchConsumer 对比不同发送线程数的吞吐量，collector 每个请求耗时 1ms
+func BenchmarkAsyncBatchConsumer(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		time.Sleep(time.Millisecond)
+	}))
+	defer srv.Close()
+	msg := map[string]interface{}{
+		"type":        "track",
+		"event":       "OrderPaid",
+		"distinct_id": "u1",
+		"properties":  map[string]interface{}{"amount": 10},
+	}
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("Workers=%d", workers), func(b *testing.B) {
+			consumer, err := sa.NewAsyncBatchConsumerWithConfig(srv.URL, sa.BatchConfig{
+				MaxBatchSize: 50,
+				BufferSize:   1000,
+				Workers:      workers,
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := consumer.Send(msg); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := consumer.Close(); err != nil {
+				b.Fatal(err)
+			}
+			b.StopTimer()
+			if stats := consumer.Stats(); stats.Sent != int64(b.N) {
+				b.Fatalf("sent %d of %d messages: %+v", stats.Sent, b.N, stats)
+			}
+		})
+	}
+}
+
+func TestConsoleConsumerFormats(t *testing.T) {
+	msg := map[string]interface{}{
+		"type":        "track",
+		"event":       "OrderPaid",
+		"distinct_id": "u1",
+		"properties":  map[string]interface{}{"amount": 10, "$lib": "golang"},
+	}
+	tests := []struct {
+		name   string
+		format sa.ConsoleFormat
+		want   string
+	}{
+		{"jsonl", sa.ConsoleJSONL, `{"distinct_id":"u1","event":"OrderPaid","properties":{"$lib":"golang","amount":10},"type":"track"}` + "\n"},
+		{"summary", sa.ConsoleSummary, "track OrderPaid user=u1 amount=10\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := sa.NewConsoleConsumerWithWriter(&buf, tt.format).Send(msg); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestConsoleConsumerZeroValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stdout")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = f
+	defer func() { os.Stdout = stdout }()
+
+	var consumer sa.ConsoleConsumer
+	if err := consumer.Send(map[string]interface{}{"distinct_id": "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	b, _ := ioutil.ReadFile(path)
+	if !strings.Contains(string(b), `"distinct_id": "u1"`) {
+		t.Errorf("zero-value ConsoleConsumer wrote %q to stdout", b)
+	}
+}
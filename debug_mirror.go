@@ -0,0 +1,255 @@
+package sensorsanalytics
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDebugMirrorBufferSize 等待发送到 Debug API 的数据条数上限
+	DefaultDebugMirrorBufferSize = 100
+	// DefaultDebugMirrorRecent DebugMirrorReport 保留的最近被拒绝的数据条数
+	DefaultDebugMirrorRecent = 20
+)
+
+// DebugMirrorConfig DebugMirrorConsumer 的配置
+type DebugMirrorConfig struct {
+	// Rate 镜像到 Debug API 的数据比例，取值 (0, 1]
+	Rate float64
+	// BufferSize 等待发送到 Debug API 的数据条数上限，超出时丢弃，默认 DefaultDebugMirrorBufferSize
+	BufferSize int
+	// Recent 报告中保留的最近被拒绝的数据条数，默认 DefaultDebugMirrorRecent
+	Recent int
+	// OnReject Debug API 拒绝数据时调用，为空时输出到日志
+	OnReject func(rejection DebugRejection)
+}
+
+func (config DebugMirrorConfig) withDefaults() DebugMirrorConfig {
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultDebugMirrorBufferSize
+	}
+	if config.Recent <= 0 {
+		config.Recent = DefaultDebugMirrorRecent
+	}
+	return config
+}
+
+// DebugRejection 一条被 Debug API 拒绝的数据
+type DebugRejection struct {
+	Time       time.Time
+	Type       string
+	Event      string
+	DistinctID string
+	Err        *DebugError
+}
+
+// DebugMirrorReport 某一时刻的镜像统计
+type DebugMirrorReport struct {
+	// Mirrored 抽样后进入队列的数据条数
+	Mirrored int64
+	// Dropped 队列已满被丢弃的数据条数
+	Dropped int64
+	// Accepted Debug API 检查通过的数据条数
+	Accepted int64
+	// Rejected Debug API 拒绝的数据条数
+	Rejected int64
+	// Failed 因网络等原因未能检查的数据条数
+	Failed int64
+	// RejectedEvents 按事件名称 (非 track 数据为类型) 统计的被拒绝条数
+	RejectedEvents map[string]int64
+	// RejectedFields 按字段统计的被拒绝条数
+	RejectedFields map[string]int64
+	// Recent 最近被拒绝的数据，按时间先后排列
+	Recent []DebugRejection
+}
+
+// DebugMirrorConsumer 将所有数据交给主 Consumer 发送，同时抽样一部分数据以 Dry-Run 方式
+// 发送到 Debug API 检查格式，用于在生产环境中持续发现有误的数据。
+// 镜像在后台进行，不影响主 Consumer 的发送，其结果也不会返回给调用方。
+type DebugMirrorConsumer struct {
+	primary Consumer
+	debug   *DebugConsumer
+	config  DebugMirrorConfig
+	queue   chan []byte
+	wg      sync.WaitGroup
+
+	// lock 保护 closed 及统计数据
+	lock           sync.Mutex
+	closed         bool
+	mirrored       int64
+	dropped        int64
+	accepted       int64
+	rejected       int64
+	failed         int64
+	rejectedEvents map[string]int64
+	rejectedFields map[string]int64
+	recent         []DebugRejection
+}
+
+// NewDebugMirrorConsumer 创建新的 DebugMirrorConsumer
+// :param primary: 实际发送数据的 Consumer
+// :param serverURL: 接收服务器地址，与 NewDebugConsumer 相同，路径会替换为 /debug
+// :param config: 镜像配置
+func NewDebugMirrorConsumer(primary Consumer, serverURL string, config DebugMirrorConfig) (*DebugMirrorConsumer, error) {
+	if primary == nil {
+		return nil, errors.New("primary consumer must not be nil")
+	}
+	if config.Rate <= 0 || config.Rate > 1 {
+		return nil, errors.New("rate must be in (0, 1]")
+	}
+	debug, err := NewDebugConsumer(serverURL, false)
+	if err != nil {
+		return nil, err
+	}
+	config = config.withDefaults()
+	c := &DebugMirrorConsumer{
+		primary:        primary,
+		debug:          debug,
+		config:         config,
+		queue:          make(chan []byte, config.BufferSize),
+		rejectedEvents: map[string]int64{},
+		rejectedFields: map[string]int64{},
+	}
+	c.wg.Add(1)
+	go c.run()
+	return c, nil
+}
+
+// DebugConsumer 返回发送到 Debug API 的 Consumer，可以通过它设置 HTTP Client 等
+func (c *DebugMirrorConsumer) DebugConsumer() *DebugConsumer {
+	return c.debug
+}
+
+// Send 将数据交给主 Consumer，并按比例镜像到 Debug API
+func (c *DebugMirrorConsumer) Send(msg map[string]interface{}) error {
+	err := c.primary.Send(msg)
+	if rand.Float64() < c.config.Rate {
+		c.mirror(msg)
+	}
+	return err
+}
+
+// Flush 对主 Consumer 调用 Flush，不等待镜像完成
+func (c *DebugMirrorConsumer) Flush() error {
+	return c.primary.Flush()
+}
+
+// Close 等待队列中的数据检查完成，然后关闭主 Consumer
+func (c *DebugMirrorConsumer) Close() error {
+	c.lock.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.queue)
+	}
+	c.lock.Unlock()
+	c.wg.Wait()
+	return c.primary.Close()
+}
+
+// Purge 删除主 Consumer 中指定用户尚未发送的数据
+func (c *DebugMirrorConsumer) Purge(distinctID string) int {
+	return purge(c.primary, distinctID)
+}
+
+// Report 返回当前的镜像统计
+func (c *DebugMirrorConsumer) Report() DebugMirrorReport {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	report := DebugMirrorReport{
+		Mirrored:       c.mirrored,
+		Dropped:        c.dropped,
+		Accepted:       c.accepted,
+		Rejected:       c.rejected,
+		Failed:         c.failed,
+		RejectedEvents: make(map[string]int64, len(c.rejectedEvents)),
+		RejectedFields: make(map[string]int64, len(c.rejectedFields)),
+		Recent:         make([]DebugRejection, len(c.recent)),
+	}
+	for k, v := range c.rejectedEvents {
+		report.RejectedEvents[k] = v
+	}
+	for k, v := range c.rejectedFields {
+		report.RejectedFields[k] = v
+	}
+	copy(report.Recent, c.recent)
+	return report
+}
+
+// mirror 将数据编码后放入队列，调用方之后修改 msg 不影响镜像的内容
+func (c *DebugMirrorConsumer) mirror(msg map[string]interface{}) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return
+	}
+	select {
+	case c.queue <- b:
+		c.mirrored++
+	default:
+		c.dropped++
+	}
+}
+
+func (c *DebugMirrorConsumer) run() {
+	defer c.wg.Done()
+	for b := range c.queue {
+		var msg map[string]interface{}
+		decoder := json.NewDecoder(bytes.NewReader(b))
+		decoder.UseNumber()
+		if err := decoder.Decode(&msg); err != nil {
+			continue
+		}
+		c.record(msg, c.debug.Send(msg))
+	}
+}
+
+func (c *DebugMirrorConsumer) record(msg map[string]interface{}, err error) {
+	var debugErr *DebugError
+	if err != nil && !errors.As(err, &debugErr) {
+		c.lock.Lock()
+		c.failed++
+		c.lock.Unlock()
+		return
+	}
+	if debugErr == nil {
+		c.lock.Lock()
+		c.accepted++
+		c.lock.Unlock()
+		return
+	}
+
+	rejection := DebugRejection{Time: time.Now(), Err: debugErr}
+	rejection.Type, _ = msg["type"].(string)
+	rejection.Event, _ = msg["event"].(string)
+	rejection.DistinctID, _ = msg["distinct_id"].(string)
+	name := rejection.Event
+	if name == "" {
+		name = rejection.Type
+	}
+	c.lock.Lock()
+	c.rejected++
+	c.rejectedEvents[name]++
+	for _, f := range debugErr.Fields {
+		c.rejectedFields[f.Field]++
+	}
+	c.recent = append(c.recent, rejection)
+	if len(c.recent) > c.config.Recent {
+		c.recent = c.recent[len(c.recent)-c.config.Recent:]
+	}
+	c.lock.Unlock()
+
+	if c.config.OnReject != nil {
+		c.config.OnReject(rejection)
+		return
+	}
+	log.Printf("DebugMirrorConsumer: %s %s rejected: %s", rejection.Type, rejection.Event, debugErr)
+}
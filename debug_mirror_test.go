@@ -0,0 +1,175 @@
+package sensorsanalytics_test
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/satest"
+)
+
+func newMirror(t *testing.T, primary sa.Consumer, config sa.DebugMirrorConfig) (*sa.DebugMirrorConsumer, *satest.Collector) {
+	t.Helper()
+	collector := satest.NewCollector()
+	t.Cleanup(collector.Close)
+	mirror, err := sa.NewDebugMirrorConsumer(primary, collector.URL(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mirror, collector
+}
+
+func TestDebugMirrorRate(t *testing.T) {
+	rec := satest.NewRecordingConsumer()
+	mirror, collector := newMirror(t, rec, sa.DebugMirrorConfig{Rate: 0.3, BufferSize: 2000})
+	const n = 2000
+	for i := 0; i < n; i++ {
+		if err := mirror.Send(trackMsg("u1", nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mirror.Close(); err != nil {
+		t.Fatal(err)
+	}
+	report := mirror.Report()
+	if report.Dropped != 0 || report.Mirrored < n*2/10 || report.Mirrored > n*4/10 {
+		t.Errorf("mirrored %d and dropped %d of %d, want about 30%% mirrored", report.Mirrored, report.Dropped, n)
+	}
+	if report.Accepted != report.Mirrored || int64(len(collector.Requests())) != report.Mirrored {
+		t.Errorf("accepted %d, collector received %d, want %d", report.Accepted, len(collector.Requests()), report.Mirrored)
+	}
+	// 镜像只检查不保存，主 Consumer 收到所有数据
+	if len(collector.Events()) != 0 || len(rec.Envelopes()) != n {
+		t.Errorf("collector saved %d events, primary received %d", len(collector.Events()), len(rec.Envelopes()))
+	}
+}
+
+func TestDebugMirrorQueueFull(t *testing.T) {
+	rec := satest.NewRecordingConsumer()
+	rec.SetError(errors.New("primary down"))
+	mirror, collector := newMirror(t, rec, sa.DebugMirrorConfig{Rate: 1, BufferSize: 2})
+	collector.SetLatency(50 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		// 主 Consumer 的错误原样返回，镜像不受影响
+		if err := mirror.Send(trackMsg("u1", nil)); err == nil || err.Error() != "primary down" {
+			t.Fatalf("Send() = %v, want the primary's error", err)
+		}
+	}
+	report := mirror.Report()
+	if report.Dropped == 0 || report.Mirrored+report.Dropped != 10 {
+		t.Errorf("mirrored %d, dropped %d, want some of the 10 dropped", report.Mirrored, report.Dropped)
+	}
+	mirror.Close()
+	if after := mirror.Report(); after.Accepted != report.Mirrored {
+		t.Errorf("accepted %d after Close, want %d", after.Accepted, report.Mirrored)
+	}
+}
+
+func TestDebugMirrorReport(t *testing.T) {
+	var lock sync.Mutex
+	var rejections []sa.DebugRejection
+	mirror, _ := newMirror(t, satest.NewRecordingConsumer(), sa.DebugMirrorConfig{
+		Rate:   1,
+		Recent: 2,
+		OnReject: func(rejection sa.DebugRejection) {
+			lock.Lock()
+			defer lock.Unlock()
+			rejections = append(rejections, rejection)
+		},
+	})
+	invalid := func(event string, distinctID string) map[string]interface{} {
+		msg := trackMsg("", nil)
+		msg["event"] = event
+		msg["distinct_id"] = distinctID
+		return msg
+	}
+	mirror.Send(invalid("SignIn", ""))
+	mirror.Send(trackMsg("u1", nil))
+	mirror.Send(invalid("PageView", ""))
+	mirror.Send(invalid("PageView", ""))
+	mirror.Close()
+
+	report := mirror.Report()
+	if report.Mirrored != 4 || report.Accepted != 1 || report.Rejected != 3 || report.Failed != 0 {
+		t.Errorf("report %+v, want 4 mirrored, 1 accepted and 3 rejected", report)
+	}
+	if want := map[string]int64{"SignIn": 1, "PageView": 2}; !reflect.DeepEqual(report.RejectedEvents, want) {
+		t.Errorf("rejected events %v, want %v", report.RejectedEvents, want)
+	}
+	if want := map[string]int64{"distinct_id": 3}; !reflect.DeepEqual(report.RejectedFields, want) {
+		t.Errorf("rejected fields %v, want %v", report.RejectedFields, want)
+	}
+	// Recent 只保留最近的两条
+	if len(report.Recent) != 2 || report.Recent[0].Event != "PageView" || report.Recent[1].Event != "PageView" {
+		t.Errorf("recent %+v, want the last two rejections", report.Recent)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(rejections) != 3 || rejections[0].Event != "SignIn" || rejections[0].Type != "track" {
+		t.Fatalf("OnReject got %+v, want 3 rejections starting with SignIn", rejections)
+	}
+	if err := rejections[0].Err; err == nil || len(err.Fields) != 1 || err.Fields[0].Field != "distinct_id" || err.StatusCode != http.StatusBadRequest {
+		t.Errorf("rejection error %+v, want a 400 for distinct_id", err)
+	}
+}
+
+func TestDebugMirrorFailed(t *testing.T) {
+	mirror, collector := newMirror(t, satest.NewRecordingConsumer(), sa.DebugMirrorConfig{Rate: 1})
+	collector.SetStatus(http.StatusServiceUnavailable)
+	mirror.Send(trackMsg("u1", nil))
+	mirror.Close()
+	if report := mirror.Report(); report.Failed != 1 || report.Rejected != 0 {
+		t.Errorf("report %+v, want the server error counted as failed", report)
+	}
+}
+
+// closeRecorder 在 Close 时调用 onClose
+type closeRecorder struct {
+	*satest.RecordingConsumer
+	onClose func()
+}
+
+func (c *closeRecorder) Close() error {
+	c.onClose()
+	return c.RecordingConsumer.Close()
+}
+
+func TestDebugMirrorCloseDrainsBeforePrimary(t *testing.T) {
+	var mirror *sa.DebugMirrorConsumer
+	var checkedAtClose int64 = -1
+	primary := &closeRecorder{RecordingConsumer: satest.NewRecordingConsumer()}
+	primary.onClose = func() {
+		checkedAtClose = mirror.Report().Accepted
+	}
+	mirror, collector := newMirror(t, primary, sa.DebugMirrorConfig{Rate: 1})
+	collector.SetLatency(10 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		mirror.Send(trackMsg("u1", nil))
+	}
+	if err := mirror.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if checkedAtClose != 5 || primary.Closes() != 1 {
+		t.Errorf("primary closed %d times after %d mirrored messages were checked, want once after 5", primary.Closes(), checkedAtClose)
+	}
+	// Close 之后不再镜像，也不会 panic
+	mirror.Send(trackMsg("u1", nil))
+	if report := mirror.Report(); report.Mirrored != 5 {
+		t.Errorf("mirrored %d after Close, want 5", report.Mirrored)
+	}
+}
+
+func TestNewDebugMirrorConsumerValidation(t *testing.T) {
+	if _, err := sa.NewDebugMirrorConsumer(nil, "http://localhost/sa", sa.DebugMirrorConfig{Rate: 1}); err == nil {
+		t.Error("nil primary accepted")
+	}
+	for _, rate := range []float64{0, -0.5, 1.5} {
+		if _, err := sa.NewDebugMirrorConsumer(satest.NewRecordingConsumer(), "http://localhost/sa", sa.DebugMirrorConfig{Rate: rate}); err == nil {
+			t.Errorf("rate %v accepted", rate)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package sensorsanalytics_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+func TestDebugConsumerErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		wantNil     bool
+		wantStatus  bool
+		wantMessage string
+		wantFields  []sa.DebugField
+	}{
+		{name: "accepted", status: http.StatusOK, wantNil: true},
+		{
+			name:        "fields",
+			status:      http.StatusBadRequest,
+			body:        `{"error":"invalid data","details":[{"field":"distinct_id","message":"must not be empty"},{"field":"time","message":"too old"}]}`,
+			wantMessage: "invalid data",
+			wantFields:  []sa.DebugField{{Field: "distinct_id", Message: "must not be empty"}, {Field: "time", Message: "too old"}},
+		},
+		{name: "message only", status: http.StatusBadRequest, body: `{"message":"project not found"}`, wantMessage: "project not found"},
+		{name: "plain text", status: http.StatusBadRequest, body: "bad data\n", wantMessage: "bad data"},
+		{name: "empty body", status: http.StatusForbidden, wantMessage: ""},
+		{name: "unexpected json", status: http.StatusBadRequest, body: `{"details":"oops"}`, wantMessage: `{"details":"oops"}`},
+		{name: "server error", status: http.StatusBadGateway, body: "upstream down", wantStatus: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+			consumer, err := sa.NewDebugConsumer(srv.URL+"/sa?project=default", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = consumer.Send(trackMsg("u1", nil))
+			if path != "/debug" {
+				t.Errorf("request path %q, want /debug", path)
+			}
+			if tt.wantNil {
+				if err != nil {
+					t.Errorf("Send() = %v, want nil", err)
+				}
+				return
+			}
+			if tt.wantStatus {
+				var statusErr *sa.StatusError
+				if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.status || errors.Is(err, sa.ErrDebugException) {
+					t.Errorf("Send() = %v, want a StatusError that is not a debug exception", err)
+				}
+				return
+			}
+			var debugErr *sa.DebugError
+			if !errors.As(err, &debugErr) || !errors.Is(err, sa.ErrDebugException) {
+				t.Fatalf("Send() = %#v, want *DebugError", err)
+			}
+			if debugErr.StatusCode != tt.status || debugErr.Message != tt.wantMessage || debugErr.Body != tt.body {
+				t.Errorf("got code %d message %q body %q", debugErr.StatusCode, debugErr.Message, debugErr.Body)
+			}
+			if !reflect.DeepEqual(debugErr.Fields, tt.wantFields) {
+				t.Errorf("fields %+v, want %+v", debugErr.Fields, tt.wantFields)
+			}
+			for _, f := range tt.wantFields {
+				if !strings.Contains(err.Error(), f.Field) {
+					t.Errorf("Error() = %q, want it to name %s", err.Error(), f.Field)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package sensorsanalytics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Event 从发送的数据中解码得到的一条数据
+type Event struct {
+	// Type 数据类型，例如 "track"、"profile_set"、"item_set"
+	Type       string
+	Event      string
+	DistinctID string
+	OriginalID string
+	// ItemType 及 ItemID 只在 item_* 数据中存在
+	ItemType   string
+	ItemID     string
+	Project    string
+	Time       int64
+	TimeFree   bool
+	Properties map[string]interface{}
+	Lib        map[string]interface{}
+	// Raw 解码后的完整数据，数字保持为 json.Number
+	Raw map[string]interface{}
+}
+
+// IsTrack 是否为 track 或 track_signup 数据
+func (e Event) IsTrack() bool {
+	return e.Type == "track" || e.Type == "track_signup"
+}
+
+// IsProfile 是否为 profile_* 数据
+func (e Event) IsProfile() bool {
+	return strings.HasPrefix(e.Type, "profile_")
+}
+
+// IsItem 是否为 item_* 数据
+func (e Event) IsItem() bool {
+	return strings.HasPrefix(e.Type, "item_")
+}
+
+// Map 将 Event 转换为可以交给 Consumer.Send 的数据，Raw 中的其他字段保持不变
+func (e Event) Map() map[string]interface{} {
+	msg := make(map[string]interface{}, len(e.Raw)+4)
+	for k, v := range e.Raw {
+		msg[k] = v
+	}
+	set := func(key string, value string) {
+		if value != "" {
+			msg[key] = value
+		} else {
+			delete(msg, key)
+		}
+	}
+	msg["type"] = e.Type
+	if e.Time != 0 {
+		msg["time"] = e.Time
+	}
+	set("event", e.Event)
+	set("distinct_id", e.DistinctID)
+	set("original_id", e.OriginalID)
+	set("item_type", e.ItemType)
+	set("item_id", e.ItemID)
+	set("project", e.Project)
+	if e.TimeFree {
+		msg["time_free"] = true
+	} else {
+		delete(msg, "time_free")
+	}
+	if e.Properties != nil {
+		msg["properties"] = e.Properties
+	}
+	if e.Lib != nil {
+		msg["lib"] = e.Lib
+	}
+	return msg
+}
+
+// knownTypes 服务器接受的数据类型
+var knownTypes = map[string]bool{
+	"track":             true,
+	"track_signup":      true,
+	"profile_set":       true,
+	"profile_set_once":  true,
+	"profile_increment": true,
+	"profile_append":    true,
+	"profile_unset":     true,
+	"profile_delete":    true,
+	"item_set":          true,
+	"item_delete":       true,
+}
+
+// Decode 解码 data 或 data_list 参数的值，即 base64 编码 (可能经过 gzip 压缩) 的单条或一批数据。
+// 从 nginx 日志等处复制的经过 URL 编码的值也可以直接解码。
+func Decode(data string) ([]Event, error) {
+	data = strings.TrimSpace(data)
+	if strings.Contains(data, "%") {
+		unescaped, err := url.PathUnescape(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrIllegalDataException, err)
+		}
+		data = unescaped
+	}
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrIllegalDataException, err)
+	}
+	if len(b) > 2 && b[0] == 0x1f && b[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrIllegalDataException, err)
+		}
+		if b, err = ioutil.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrIllegalDataException, err)
+		}
+	}
+	return DecodeJSON(b)
+}
+
+// DecodeJSON 解码未经 base64 编码的单条 JSON 数据或 JSON 数组
+func DecodeJSON(b []byte) ([]Event, error) {
+	b = bytes.TrimSpace(b)
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	var msgs []map[string]interface{}
+	if len(b) > 0 && b[0] == '[' {
+		if err := decoder.Decode(&msgs); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrIllegalDataException, err)
+		}
+	} else {
+		var msg map[string]interface{}
+		if err := decoder.Decode(&msg); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrIllegalDataException, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	events := make([]Event, 0, len(msgs))
+	for i, msg := range msgs {
+		e, err := DecodeMessage(msg)
+		if err != nil {
+			return events, fmt.Errorf("message[%d]: %w", i, err)
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// DecodeRequest 解码发送到接收服务器的请求，支持 GET 及 POST 的 data、data_list 参数
+func DecodeRequest(r *http.Request) ([]Event, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrIllegalDataException, err)
+	}
+	if data := r.Form.Get("data_list"); data != "" {
+		return Decode(data)
+	}
+	if data := r.Form.Get("data"); data != "" {
+		return Decode(data)
+	}
+	return nil, fmt.Errorf("%w: data or data_list must not be empty", ErrIllegalDataException)
+}
+
+// DecodeMessage 将一条解码后的数据转换为 Event，没有 type 字段时根据其他字段推断
+func DecodeMessage(msg map[string]interface{}) (Event, error) {
+	if msg == nil {
+		return Event{}, fmt.Errorf("%w: message must not be null", ErrIllegalDataException)
+	}
+	e := Event{Raw: msg}
+	e.Type, _ = msg["type"].(string)
+	e.Event, _ = msg["event"].(string)
+	e.DistinctID, _ = msg["distinct_id"].(string)
+	e.OriginalID, _ = msg["original_id"].(string)
+	e.ItemType, _ = msg["item_type"].(string)
+	e.ItemID, _ = msg["item_id"].(string)
+	e.Project, _ = msg["project"].(string)
+	e.TimeFree, _ = msg["time_free"].(bool)
+	e.Properties, _ = msg["properties"].(map[string]interface{})
+	e.Lib, _ = msg["lib"].(map[string]interface{})
+	if t, ok := msg["time"]; ok {
+		ts, ok := toInt64(t)
+		if !ok {
+			return e, invalid("time", "property [time] must be int64")
+		}
+		e.Time = ts
+	}
+	if e.Type == "" {
+		e.Type = inferType(e)
+	}
+	if !knownTypes[e.Type] {
+		return e, invalid("type", "unknown message type. [type=%s]", e.Type)
+	}
+	return e, nil
+}
+
+func inferType(e Event) string {
+	switch {
+	case e.OriginalID != "" && e.Event != "":
+		return "track_signup"
+	case e.Event != "":
+		return "track"
+	case e.ItemID != "":
+		return "item_set"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,241 @@
+package sensorsanalytics_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+// decodeServer 用 DecodeRequest 解码收到的每个请求
+type decodeServer struct {
+	*httptest.Server
+	lock   sync.Mutex
+	events []sa.Event
+	forms  []url.Values
+	errs   []error
+}
+
+func newDecodeServer() *decodeServer {
+	s := &decodeServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events, err := sa.DecodeRequest(r)
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.events = append(s.events, events...)
+		s.forms = append(s.forms, r.Form)
+		if err != nil {
+			s.errs = append(s.errs, err)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	return s
+}
+
+// sendSample 通过 client 发送每种类型的数据各一条
+func sendSample(t *testing.T, client *sa.Client) {
+	t.Helper()
+	calls := []error{
+		client.Track("u1", "OrderPaid", map[string]interface{}{"amount": 10, "tags": []string{"a", "b"}, "$time": int64(1700000000123)}, true),
+		client.TrackSignup("u1", "anon-1", map[string]interface{}{"channel": "web"}),
+		client.ProfileSet("u1", map[string]interface{}{"name": "Ann"}, true),
+		client.ProfileUnset("u1", []string{"name"}, true),
+	}
+	for i, err := range calls {
+		if err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkSample 检查 sendSample 发送的数据解码后的结果
+func checkSample(t *testing.T, events []sa.Event) {
+	t.Helper()
+	if len(events) != 4 {
+		t.Fatalf("decoded %d events, want 4", len(events))
+	}
+	track, signup, set, unset := events[0], events[1], events[2], events[3]
+	if !track.IsTrack() || track.Type != "track" || track.Event != "OrderPaid" || track.DistinctID != "u1" ||
+		track.Time != 1700000000123 || track.Project != "default" || track.Lib["$lib"] != "golang" {
+		t.Errorf("track decoded as %+v", track)
+	}
+	if track.Properties["amount"] != json.Number("10") || !reflect.DeepEqual(track.Properties["tags"], []interface{}{"a", "b"}) ||
+		track.Properties["$is_login_id"] != true {
+		t.Errorf("track properties %v", track.Properties)
+	}
+	if signup.Type != "track_signup" || signup.Event != "$SignUp" || signup.OriginalID != "anon-1" || signup.Properties["channel"] != "web" {
+		t.Errorf("track_signup decoded as %+v", signup)
+	}
+	if !set.IsProfile() || set.Type != "profile_set" || set.Properties["name"] != "Ann" {
+		t.Errorf("profile_set decoded as %+v", set)
+	}
+	if unset.Type != "profile_unset" || unset.Properties["name"] != true {
+		t.Errorf("profile_unset decoded as %+v", unset)
+	}
+}
+
+func TestDecodeRequestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		consumer func(serverURL string) (sa.Consumer, error)
+		param    string
+		gzip     bool
+	}{
+		{
+			name:     "GET data",
+			consumer: func(serverURL string) (sa.Consumer, error) { return sa.NewDefaultConsumer(serverURL) },
+			param:    "data",
+		},
+		{
+			name: "POST data_list",
+			consumer: func(serverURL string) (sa.Consumer, error) {
+				return sa.NewBatchConsumer(serverURL, 10)
+			},
+			param: "data_list",
+		},
+		{
+			name: "POST gzip data_list",
+			consumer: func(serverURL string) (sa.Consumer, error) {
+				return sa.NewBatchConsumerWithConfig(serverURL, sa.BatchConfig{MaxBatchSize: 10, Gzip: true})
+			},
+			param: "data_list",
+			gzip:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newDecodeServer()
+			defer srv.Close()
+			consumer, err := tt.consumer(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, err := sa.NewClient(consumer, "default", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sendSample(t, client)
+			if len(srv.errs) > 0 {
+				t.Fatalf("DecodeRequest: %v", srv.errs)
+			}
+			checkSample(t, srv.events)
+
+			// 参数值本身也可以用 Decode 解码
+			var decoded []sa.Event
+			for _, form := range srv.forms {
+				if _, ok := form[tt.param]; !ok || (form.Get("gzip") == "1") != tt.gzip {
+					t.Fatalf("request form %v, want %s with gzip=%v", form, tt.param, tt.gzip)
+				}
+				events, err := sa.Decode(form.Get(tt.param))
+				if err != nil {
+					t.Fatal(err)
+				}
+				decoded = append(decoded, events...)
+			}
+			checkSample(t, decoded)
+		})
+	}
+}
+
+func TestDecodeURLEscaped(t *testing.T) {
+	srv := newDecodeServer()
+	defer srv.Close()
+	consumer, _ := sa.NewBatchConsumerWithConfig(srv.URL, sa.BatchConfig{MaxBatchSize: 10, Gzip: true})
+	client, _ := sa.NewClient(consumer, "default", false)
+	sendSample(t, client)
+	// 从 nginx 日志中复制的值经过 URL 编码
+	events, err := sa.Decode(url.QueryEscape(srv.forms[0].Get("data_list")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSample(t, events)
+}
+
+func TestDecodeJSON(t *testing.T) {
+	events, err := sa.DecodeJSON([]byte(` {"type":"track","event":"A","distinct_id":"u1","time":1,"properties":{}} `))
+	if err != nil || len(events) != 1 || events[0].Event != "A" || events[0].Time != 1 {
+		t.Errorf("single message decoded as %+v, %v", events, err)
+	}
+	events, err = sa.DecodeJSON([]byte(`[{"event":"A","distinct_id":"u1"},{"event":"$SignUp","distinct_id":"u1","original_id":"a1"},{"item_type":"book","item_id":"b1"},{"distinct_id":"u1","type":"profile_delete"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	if want := []string{"track", "track_signup", "item_set", "profile_delete"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("types %v, want %v", types, want)
+	}
+	if !events[2].IsItem() || events[2].ItemType != "book" || events[2].ItemID != "b1" {
+		t.Errorf("item decoded as %+v", events[2])
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, data := range []string{"not base64!", "e30=" + "x", "W3t9XQ=="} {
+		if _, err := sa.Decode(data); !errors.Is(err, sa.ErrIllegalDataException) {
+			t.Errorf("Decode(%q) = %v, want ErrIllegalDataException", data, err)
+		}
+	}
+	tests := []struct {
+		msg   map[string]interface{}
+		field string
+	}{
+		{map[string]interface{}{"type": "track", "event": "A", "time": "yesterday"}, "time"},
+		{map[string]interface{}{"type": "unknown"}, "type"},
+		{map[string]interface{}{"distinct_id": "u1"}, "type"},
+	}
+	for _, tt := range tests {
+		_, err := sa.DecodeMessage(tt.msg)
+		var verr *sa.ValidationError
+		if !errors.As(err, &verr) || verr.Field != tt.field {
+			t.Errorf("DecodeMessage(%v) = %v, want a ValidationError for %s", tt.msg, err, tt.field)
+		}
+	}
+	if _, err := sa.DecodeMessage(nil); !errors.Is(err, sa.ErrIllegalDataException) {
+		t.Errorf("DecodeMessage(nil) = %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/sa", nil)
+	if _, err := sa.DecodeRequest(req); !errors.Is(err, sa.ErrIllegalDataException) {
+		t.Errorf("DecodeRequest without data = %v", err)
+	}
+}
+
+// Map 的结果可以重新解码为相同的 Event，也可以再次发送
+func TestEventMapRoundTrip(t *testing.T) {
+	srv := newDecodeServer()
+	defer srv.Close()
+	consumer, _ := sa.NewDefaultConsumer(srv.URL)
+	client, _ := sa.NewClient(consumer, "default", false)
+	sendSample(t, client)
+	original := srv.events
+
+	for _, e := range original {
+		again, err := sa.DecodeMessage(e.Map())
+		if err != nil {
+			t.Fatal(err)
+		}
+		again.Raw, e.Raw = nil, nil
+		if !reflect.DeepEqual(again, e) {
+			t.Errorf("decoded %+v from Map(), want %+v", again, e)
+		}
+	}
+
+	srv.events = nil
+	for _, e := range original {
+		if err := consumer.Send(e.Map()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkSample(t, srv.events)
+}
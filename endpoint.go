@@ -0,0 +1,245 @@
+package sensorsanalytics
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// EndpointStrategy 从 EndpointSet 中选择服务器地址的策略
+type EndpointStrategy int
+
+const (
+	// RoundRobin 依次轮流使用每个可用的服务器地址
+	RoundRobin EndpointStrategy = iota
+	// LeastErrors 优先使用错误计数最少的服务器地址，每次发送成功会抵消一次错误
+	LeastErrors
+)
+
+const (
+	// DefaultMaxEndpointFailures 默认连续失败多少次后摘除服务器地址
+	DefaultMaxEndpointFailures = 3
+	// DefaultEjectDuration 默认摘除服务器地址后多久重新加入
+	DefaultEjectDuration = 30 * time.Second
+)
+
+// EndpointSet 一组可互相替代的服务器地址。发送失败时自动切换到下一个地址，
+// 连续失败的地址会被暂时摘除，到期后重新加入，重新加入后再次失败会立即被摘除。
+type EndpointSet struct {
+	lock          sync.Mutex
+	endpoints     []*endpoint
+	strategy      EndpointStrategy
+	next          int
+	maxFailures   int
+	ejectDuration time.Duration
+	clock         Clock
+}
+
+type endpoint struct {
+	url          string
+	requests     int64
+	errors       int64
+	errorScore   int64
+	failures     int
+	ejectedUntil time.Time
+}
+
+// EndpointStatus 服务器地址的当前状态
+type EndpointStatus struct {
+	URL string
+	// Requests 请求总数
+	Requests int64
+	// Errors 失败的请求总数
+	Errors int64
+	// ConsecutiveFailures 连续失败次数
+	ConsecutiveFailures int
+	// Ejected 是否处于摘除状态
+	Ejected bool
+	// EjectedUntil 摘除的到期时间
+	EjectedUntil time.Time
+}
+
+// NewEndpointSet 创建新的 EndpointSet
+// :param serverURLs: 服务器的 URL 地址列表
+// :param strategy: 选择服务器地址的策略
+func NewEndpointSet(serverURLs []string, strategy EndpointStrategy) (*EndpointSet, error) {
+	if len(serverURLs) == 0 {
+		return nil, errors.New("server urls must not be empty")
+	}
+	s := &EndpointSet{
+		strategy:      strategy,
+		maxFailures:   DefaultMaxEndpointFailures,
+		ejectDuration: DefaultEjectDuration,
+	}
+	for _, serverURL := range serverURLs {
+		if _, err := url.Parse(serverURL); err != nil {
+			return nil, err
+		}
+		s.endpoints = append(s.endpoints, &endpoint{url: serverURL})
+	}
+	return s, nil
+}
+
+// newSingleEndpointSet 只包含一个地址的 EndpointSet，该地址不会被摘除
+func newSingleEndpointSet(serverURL string) *EndpointSet {
+	return &EndpointSet{
+		endpoints: []*endpoint{{url: serverURL}},
+	}
+}
+
+// SetEjection 设置连续失败 maxFailures 次后摘除服务器地址 duration 时间，maxFailures 为 0 时不摘除
+func (s *EndpointSet) SetEjection(maxFailures int, duration time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.maxFailures = maxFailures
+	s.ejectDuration = duration
+}
+
+// SetClock 设置计算摘除到期时间使用的 Clock，为 nil 时使用系统时间
+func (s *EndpointSet) SetClock(clock Clock) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.clock = clock
+}
+
+// now 返回当前时间，调用时需持有锁
+func (s *EndpointSet) now() time.Time {
+	if s.clock != nil {
+		return s.clock.Now()
+	}
+	return time.Now()
+}
+
+// Len 服务器地址数量
+func (s *EndpointSet) Len() int {
+	return len(s.endpoints)
+}
+
+// Status 返回每个服务器地址的当前状态
+func (s *EndpointSet) Status() []EndpointStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	status := make([]EndpointStatus, 0, len(s.endpoints))
+	for _, e := range s.endpoints {
+		status = append(status, EndpointStatus{
+			URL:                 e.url,
+			Requests:            e.requests,
+			Errors:              e.errors,
+			ConsecutiveFailures: e.failures,
+			Ejected:             now.Before(e.ejectedUntil),
+			EjectedUntil:        e.ejectedUntil,
+		})
+	}
+	return status
+}
+
+// pick 选择一个未尝试过的可用地址。所有地址都被摘除且尚未尝试任何地址时，选择最早到期的地址；
+// 没有可选地址时返回空字符串。
+func (s *EndpointSet) pick(tried map[string]bool) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	n := len(s.endpoints)
+	chosen := -1
+	for i := 0; i < n; i++ {
+		idx := (s.next + i) % n
+		e := s.endpoints[idx]
+		if tried[e.url] || now.Before(e.ejectedUntil) {
+			continue
+		}
+		if chosen < 0 || (s.strategy == LeastErrors && e.errorScore < s.endpoints[chosen].errorScore) {
+			chosen = idx
+		}
+		if s.strategy == RoundRobin {
+			break
+		}
+	}
+	if chosen < 0 && len(tried) == 0 {
+		for idx, e := range s.endpoints {
+			if chosen < 0 || e.ejectedUntil.Before(s.endpoints[chosen].ejectedUntil) {
+				chosen = idx
+			}
+		}
+	}
+	if chosen < 0 {
+		return ""
+	}
+	s.next = (chosen + 1) % n
+	s.endpoints[chosen].requests++
+	return s.endpoints[chosen].url
+}
+
+func (s *EndpointSet) find(serverURL string) *endpoint {
+	for _, e := range s.endpoints {
+		if e.url == serverURL {
+			return e
+		}
+	}
+	return nil
+}
+
+// markSuccess 记录一次成功的发送
+func (s *EndpointSet) markSuccess(serverURL string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e := s.find(serverURL)
+	if e == nil {
+		return
+	}
+	e.failures = 0
+	if e.errorScore > 0 {
+		e.errorScore--
+	}
+}
+
+// markFailure 记录一次失败的发送，连续失败达到上限时摘除该地址
+func (s *EndpointSet) markFailure(serverURL string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e := s.find(serverURL)
+	if e == nil {
+		return
+	}
+	e.errors++
+	e.errorScore++
+	e.failures++
+	if s.maxFailures > 0 && e.failures >= s.maxFailures {
+		e.ejectedUntil = s.now().Add(s.ejectDuration)
+		// 保留失败计数，重新加入后再次失败会立即被摘除
+		e.failures = s.maxFailures - 1
+	}
+}
+
+// send 依次尝试可用的服务器地址直到发送成功，只有网络错误及 5xx 错误才会切换地址
+func (s *EndpointSet) send(fn func(serverURL string) error) error {
+	tried := make(map[string]bool, len(s.endpoints))
+	var lastErr error
+	for {
+		serverURL := s.pick(tried)
+		if serverURL == "" {
+			return lastErr
+		}
+		tried[serverURL] = true
+		err := fn(serverURL)
+		if err == nil {
+			s.markSuccess(serverURL)
+			return nil
+		}
+		if !isRetryable(err) {
+			return err
+		}
+		s.markFailure(serverURL)
+		lastErr = err
+	}
+}
+
+// isRetryable 判断错误是否可以通过重试或切换服务器地址解决
+func isRetryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == 429
+	}
+	return errors.Is(err, ErrNetworkException)
+}
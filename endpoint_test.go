@@ -0,0 +1,197 @@
+package sensorsanalytics_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/satest"
+)
+
+// newEndpointConsumer 创建 n 个 Collector 及依次使用它们的 DefaultConsumer
+func newEndpointConsumer(t *testing.T, n int) ([]*satest.Collector, *sa.EndpointSet, *sa.DefaultConsumer) {
+	t.Helper()
+	collectors := make([]*satest.Collector, n)
+	urls := make([]string, n)
+	for i := range collectors {
+		collectors[i] = satest.NewCollector()
+		t.Cleanup(collectors[i].Close)
+		urls[i] = collectors[i].URL()
+	}
+	set, err := sa.NewEndpointSet(urls, sa.RoundRobin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer, err := sa.NewDefaultConsumerWithEndpoints(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return collectors, set, consumer
+}
+
+// requestCounts 返回每个 Collector 收到的请求数
+func requestCounts(collectors []*satest.Collector) []int {
+	counts := make([]int, len(collectors))
+	for i, c := range collectors {
+		counts[i] = len(c.Requests())
+	}
+	return counts
+}
+
+func TestEndpointSetFailover(t *testing.T) {
+	collectors, _, consumer := newEndpointConsumer(t, 3)
+	collectors[0].FailNext(1, http.StatusInternalServerError)
+	if err := consumer.Send(trackMsg("u1", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if got := requestCounts(collectors); !equalInts(got, []int{1, 1, 0}) {
+		t.Errorf("requests %v after failover, want [1 1 0]", got)
+	}
+	if len(collectors[1].Events()) != 1 {
+		t.Errorf("second endpoint saved %d events, want 1", len(collectors[1].Events()))
+	}
+	// 轮流使用时下一次从成功地址的下一个开始
+	if err := consumer.Send(trackMsg("u1", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if got := requestCounts(collectors); !equalInts(got, []int{1, 1, 1}) {
+		t.Errorf("requests %v, want [1 1 1]", got)
+	}
+}
+
+func TestEndpointSetAllFailed(t *testing.T) {
+	collectors, _, consumer := newEndpointConsumer(t, 3)
+	for _, c := range collectors {
+		c.SetStatus(http.StatusServiceUnavailable)
+	}
+	err := consumer.Send(trackMsg("u1", nil))
+	var statusErr *sa.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Send() = %v, want the last StatusError", err)
+	}
+	if got := requestCounts(collectors); !equalInts(got, []int{1, 1, 1}) {
+		t.Errorf("requests %v, want each endpoint tried once", got)
+	}
+}
+
+func TestEndpointSetEjection(t *testing.T) {
+	clock := satest.NewFakeClock(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	collectors, set, consumer := newEndpointConsumer(t, 2)
+	set.SetClock(clock)
+	set.SetEjection(2, time.Minute)
+	collectors[0].SetStatus(http.StatusBadGateway)
+	send := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if err := consumer.Send(trackMsg("u1", nil)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	send(2)
+	status := set.Status()[0]
+	if !status.Ejected || status.Errors != 2 || !status.EjectedUntil.Equal(clock.Now().Add(time.Minute)) {
+		t.Fatalf("status %+v after 2 failures, want ejected for a minute", status)
+	}
+	send(3)
+	if got := requestCounts(collectors); !equalInts(got, []int{2, 5}) {
+		t.Errorf("requests %v while ejected, want [2 5]", got)
+	}
+
+	// 到期后重新加入，再次失败立即被摘除
+	clock.Advance(time.Minute)
+	if set.Status()[0].Ejected {
+		t.Fatal("endpoint still ejected after the eject duration")
+	}
+	send(1)
+	if status := set.Status()[0]; !status.Ejected || status.Errors != 3 {
+		t.Fatalf("status %+v after failing once more, want ejected again", status)
+	}
+
+	collectors[0].SetStatus(0)
+	clock.Advance(time.Minute)
+	send(1)
+	if status := set.Status()[0]; status.Ejected || status.ConsecutiveFailures != 0 || len(collectors[0].Events()) != 1 {
+		t.Errorf("status %+v after recovering, want re-admitted with no failures", status)
+	}
+}
+
+func TestEndpointSetRetryableErrors(t *testing.T) {
+	closed := satest.NewCollector()
+	closed.Close()
+	tests := []struct {
+		name      string
+		status    int
+		closed    bool
+		decorator sa.RequestDecorator
+		failover  bool
+	}{
+		{name: "5xx", status: http.StatusInternalServerError, failover: true},
+		{name: "429", status: http.StatusTooManyRequests, failover: true},
+		{name: "network error", closed: true, failover: true},
+		{name: "4xx", status: http.StatusBadRequest},
+		{name: "decorator error", decorator: func(req *http.Request) error { return errSign }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			second := satest.NewCollector()
+			defer second.Close()
+			first := satest.NewCollector()
+			defer first.Close()
+			firstURL := first.URL()
+			if tt.closed {
+				firstURL = closed.URL()
+			}
+			set, err := sa.NewEndpointSet([]string{firstURL, second.URL()}, sa.RoundRobin)
+			if err != nil {
+				t.Fatal(err)
+			}
+			consumer, err := sa.NewDefaultConsumerWithEndpoints(set)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.status != 0 {
+				first.FailNext(1, tt.status)
+			}
+			if tt.decorator != nil {
+				consumer.AddRequestDecorator(tt.decorator)
+			}
+
+			err = consumer.Send(trackMsg("u1", nil))
+			if failedOver := len(second.Requests()) == 1; failedOver != tt.failover {
+				t.Errorf("failed over = %v, want %v (err %v)", failedOver, tt.failover, err)
+			}
+			if tt.failover {
+				if err != nil || set.Status()[0].Errors != 1 {
+					t.Errorf("Send() = %v, errors %d, want nil and 1", err, set.Status()[0].Errors)
+				}
+				return
+			}
+			if err == nil || set.Status()[0].Errors != 0 {
+				t.Errorf("Send() = %v, errors %d, want an error not counted against the endpoint", err, set.Status()[0].Errors)
+			}
+		})
+	}
+}
+
+var errSign = errors.New("sign request")
+
+func TestRequestDecoratorErrorIsNotNetworkError(t *testing.T) {
+	collector := satest.NewCollector()
+	defer collector.Close()
+	consumer, err := sa.NewDefaultConsumer(collector.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer.AddRequestDecorator(func(req *http.Request) error { return errSign })
+	err = consumer.Send(trackMsg("u1", nil))
+	if err != errSign {
+		t.Errorf("Send() = %v, want the decorator's error unchanged", err)
+	}
+	if len(collector.Requests()) != 0 {
+		t.Errorf("collector received %d requests, want 0", len(collector.Requests()))
+	}
+}
@@ -1,7 +1,83 @@
 package sensorsanalytics
 
-import "errors"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
 
 var ErrIllegalDataException = errors.New("在发送的数据格式有误时，SDK会抛出此异常，用户应当捕获并处理。")
 var ErrNetworkException = errors.New("在因为网络或者不可预知的问题导致数据无法发送时，SDK会抛出此异常，用户应当捕获并处理。")
 var ErrDebugException = errors.New("Debug模式专用的异常")
+var ErrCircuitOpen = errors.New("熔断器已打开，数据未发送")
+var ErrNoRoute = errors.New("没有匹配的路由规则，数据未发送")
+var ErrConsumerClosed = errors.New("Consumer 已关闭，无法继续发送数据")
+var ErrNoBoundClient = errors.New("context 中没有绑定用户的 Client")
+
+// StatusError 服务器返回了非 200 的状态码，可以通过 errors.Is(err, ErrNetworkException) 判断
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: Error response status code [code=%d]", ErrNetworkException, e.StatusCode)
+}
+
+// Unwrap 返回 ErrNetworkException
+func (e *StatusError) Unwrap() error {
+	return ErrNetworkException
+}
+
+// DebugError Debug API 拒绝了数据，可以通过 errors.Is(err, ErrDebugException) 判断
+type DebugError struct {
+	StatusCode int
+	// Message 服务器返回的错误说明
+	Message string
+	// Fields 有误的字段，服务器没有返回时为空
+	Fields []DebugField
+	// Body 服务器返回的原始内容
+	Body string
+}
+
+// DebugField Debug API 返回的一个有误的字段
+type DebugField struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *DebugError) Error() string {
+	if len(e.Fields) == 0 {
+		return fmt.Sprintf("%s: %s [code=%d]", ErrDebugException, e.Message, e.StatusCode)
+	}
+	fields := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		fields = append(fields, f.Field)
+	}
+	return fmt.Sprintf("%s: %s [code=%d, fields=%s]", ErrDebugException, e.Message, e.StatusCode, strings.Join(fields, ","))
+}
+
+// Unwrap 返回 ErrDebugException
+func (e *DebugError) Unwrap() error {
+	return ErrDebugException
+}
+
+// parseDebugError 解析 Debug API 返回的内容，内容不是 JSON 时以原文作为 Message
+func parseDebugError(statusCode int, body []byte) *DebugError {
+	e := &DebugError{StatusCode: statusCode, Body: string(body)}
+	var resp struct {
+		Error   string       `json:"error"`
+		Message string       `json:"message"`
+		Details []DebugField `json:"details"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		e.Message = strings.TrimSpace(string(body))
+		return e
+	}
+	e.Message = resp.Error
+	if e.Message == "" {
+		e.Message = resp.Message
+	}
+	e.Fields = resp.Details
+	return e
+}
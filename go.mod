@@ -0,0 +1,3 @@
+module gopkg.in/CuriosityChina/sa-sdk-go.v1
+
+go 1.18
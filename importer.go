@@ -0,0 +1,462 @@
+package sensorsanalytics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/internal/atomicfile"
+)
+
+// ImportFormat 历史数据文件的格式
+type ImportFormat int
+
+const (
+	// ImportJSONL 每行一个 JSON 对象，properties 字段为对象时合并到属性中
+	ImportJSONL ImportFormat = iota
+	// ImportCSV 第一行为列名的 CSV
+	ImportCSV
+)
+
+// ColumnType CSV 列的取值类型，JSONL 中的值保持 JSON 的类型
+type ColumnType int
+
+const (
+	// ColumnString 字符串，默认类型
+	ColumnString ColumnType = iota
+	// ColumnNumber 数字
+	ColumnNumber
+	// ColumnBool 布尔值，取值 true/false/1/0
+	ColumnBool
+	// ColumnList 以 ListSeparator 分隔的字符串列表
+	ColumnList
+)
+
+const (
+	// DefaultCheckpointEvery 默认每导入多少行保存一次进度
+	DefaultCheckpointEvery = 1000
+	// DefaultMaxImportErrors ImportResult 中默认保留的失败行数
+	DefaultMaxImportErrors = 1000
+)
+
+// ImportConfig 导入历史数据的配置
+type ImportConfig struct {
+	Format ImportFormat
+	// DistinctIDColumn 用户标识所在的列，默认 "distinct_id"
+	DistinctIDColumn string
+	// EventColumn 事件名称所在的列，默认 "event"
+	EventColumn string
+	// TimeColumn 事件时间所在的列，默认 "time"。取值为秒或毫秒级时间戳，或符合 TimeLayout 的字符串
+	TimeColumn string
+	// TimeLayout 时间字符串的格式，默认 time.RFC3339
+	TimeLayout string
+	// IsLoginID distinct_id 是否为登录 ID
+	IsLoginID bool
+	// Properties 作为事件属性的列，为空时使用除上述列以外的所有列
+	Properties []string
+	// Types CSV 各列的取值类型，未设置的列为 ColumnString
+	Types map[string]ColumnType
+	// ListSeparator ColumnList 的分隔符，默认 ";"
+	ListSeparator string
+	// Rate 每秒最多导入的行数，为 0 时不限制
+	Rate float64
+	// CheckpointPath 保存导入进度的文件，设置后可以在中断后从上次的位置继续导入
+	CheckpointPath string
+	// CheckpointEvery 每导入多少行调用一次 Flush 并保存进度，默认 DefaultCheckpointEvery
+	CheckpointEvery int
+	// MaxErrors ImportResult 中保留的失败行数，默认 DefaultMaxImportErrors
+	MaxErrors int
+	// OnError 某一行导入失败时调用
+	OnError func(err RowError)
+}
+
+func (config ImportConfig) withDefaults() ImportConfig {
+	if config.DistinctIDColumn == "" {
+		config.DistinctIDColumn = "distinct_id"
+	}
+	if config.EventColumn == "" {
+		config.EventColumn = "event"
+	}
+	if config.TimeColumn == "" {
+		config.TimeColumn = "time"
+	}
+	if config.TimeLayout == "" {
+		config.TimeLayout = time.RFC3339
+	}
+	if config.ListSeparator == "" {
+		config.ListSeparator = ";"
+	}
+	if config.CheckpointEvery <= 0 {
+		config.CheckpointEvery = DefaultCheckpointEvery
+	}
+	if config.MaxErrors <= 0 {
+		config.MaxErrors = DefaultMaxImportErrors
+	}
+	return config
+}
+
+// RowError 导入失败的一行
+type RowError struct {
+	// Row 数据行的序号，从 1 开始，不包括 CSV 的列名行及 JSONL 的空行
+	Row int
+	Err error
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("row %d: %s", e.Row, e.Err)
+}
+
+// Unwrap 返回该行的错误
+func (e RowError) Unwrap() error {
+	return e.Err
+}
+
+// ImportResult 导入结果
+type ImportResult struct {
+	// Rows 读取的数据行数，包括跳过的行
+	Rows int
+	// Imported 导入成功的行数
+	Imported int
+	// Skipped 根据进度文件跳过的行数
+	Skipped int
+	// Failed 导入失败的行数
+	Failed int
+	// Errors 失败的行，最多保留 MaxErrors 条
+	Errors []RowError
+}
+
+// importCheckpoint 进度文件的内容
+type importCheckpoint struct {
+	Row int `json:"row"`
+}
+
+// Importer 导入历史数据，所有事件均带有 time_free，不影响其他 Client 的设置
+type Importer struct {
+	client *Client
+	config ImportConfig
+}
+
+// NewImporter 创建新的 Importer。保存进度前调用 consumer.Flush，Flush 必须在数据发送完成后才返回，
+// 否则进度会先于数据保存，中断后未发送的数据不会再导入，因此不支持 AsyncBatchConsumer。
+// :param consumer: 发送数据的 Consumer，建议使用 BatchConsumer
+// :param projectName: 项目名称
+// :param config: 导入配置
+func NewImporter(consumer Consumer, projectName string, config ImportConfig) (*Importer, error) {
+	if config.Rate < 0 {
+		return nil, errors.New("rate must not be negative")
+	}
+	if _, ok := consumer.(*AsyncBatchConsumer); ok {
+		return nil, errors.New("importer does not support AsyncBatchConsumer: its Flush returns before the data is sent")
+	}
+	client, err := NewClient(consumer, projectName, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Importer{client: client, config: config.withDefaults()}, nil
+}
+
+// Client 返回发送数据的 Client，可以设置中间件、脱敏规则等
+func (im *Importer) Client() *Client {
+	return im.client
+}
+
+// Import 从 r 读取数据并导入。ctx 取消时保存进度并返回 ctx.Err()，
+// 之后使用相同的 CheckpointPath 再次导入时从中断的位置继续。
+func (im *Importer) Import(ctx context.Context, r io.Reader) (ImportResult, error) {
+	var result ImportResult
+	done, err := im.loadCheckpoint()
+	if err != nil {
+		return result, err
+	}
+	records, err := im.records(r)
+	if err != nil {
+		return result, err
+	}
+
+	var interval time.Duration
+	if im.config.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / im.config.Rate)
+	}
+	next := time.Now()
+	sinceCheckpoint := 0
+	for {
+		record, err := records()
+		if err == io.EOF {
+			break
+		}
+		if err != nil && !errors.Is(err, ErrIllegalDataException) {
+			// 读取失败，无法继续
+			return result, im.checkpoint(result.Rows, err)
+		}
+		result.Rows++
+		row := result.Rows
+		if row <= done {
+			result.Skipped++
+			continue
+		}
+		if err == nil {
+			if err = ctx.Err(); err != nil {
+				result.Rows--
+				return result, im.checkpoint(row-1, err)
+			}
+			if interval > 0 {
+				if err = waitUntil(ctx, next); err != nil {
+					result.Rows--
+					return result, im.checkpoint(row-1, err)
+				}
+				next = next.Add(interval)
+			}
+			err = im.importRecord(record)
+		}
+		if err != nil {
+			im.fail(&result, RowError{Row: row, Err: err})
+		} else {
+			result.Imported++
+		}
+		sinceCheckpoint++
+		if sinceCheckpoint >= im.config.CheckpointEvery {
+			sinceCheckpoint = 0
+			if err := im.checkpoint(row, nil); err != nil {
+				return result, err
+			}
+		}
+	}
+	return result, im.checkpoint(result.Rows, nil)
+}
+
+func (im *Importer) fail(result *ImportResult, rowErr RowError) {
+	result.Failed++
+	if len(result.Errors) < im.config.MaxErrors {
+		result.Errors = append(result.Errors, rowErr)
+	}
+	if im.config.OnError != nil {
+		im.config.OnError(rowErr)
+	}
+}
+
+// importRecord 将一行数据转换为事件并发送
+func (im *Importer) importRecord(record map[string]interface{}) error {
+	config := im.config
+	distinctID, ok := record[config.DistinctIDColumn].(string)
+	if !ok || distinctID == "" {
+		return invalid("distinct_id", "column [%s] must not be empty", config.DistinctIDColumn)
+	}
+	event, ok := record[config.EventColumn].(string)
+	if !ok || event == "" {
+		return invalid("event", "column [%s] must not be empty", config.EventColumn)
+	}
+	ts, err := im.parseTime(record[config.TimeColumn])
+	if err != nil {
+		return err
+	}
+
+	properties := map[string]interface{}{}
+	if nested, ok := record["properties"].(map[string]interface{}); ok {
+		for k, v := range nested {
+			properties[k] = v
+		}
+	}
+	if len(config.Properties) > 0 {
+		for _, column := range config.Properties {
+			if v, ok := record[column]; ok {
+				properties[column] = v
+			}
+		}
+	} else {
+		for column, v := range record {
+			switch column {
+			case config.DistinctIDColumn, config.EventColumn, config.TimeColumn, "properties":
+				continue
+			}
+			properties[column] = v
+		}
+	}
+	properties["$time"] = ts
+	return im.client.Track(distinctID, event, properties, config.IsLoginID)
+}
+
+// parseTime 将秒或毫秒级时间戳及时间字符串转换为毫秒级时间戳
+func (im *Importer) parseTime(value interface{}) (int64, error) {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return 0, invalid("time", "column [%s] must not be empty", im.config.TimeColumn)
+	case string:
+		s = strings.TrimSpace(v)
+	case json.Number:
+		s = v.String()
+	default:
+		s = fmt.Sprint(v)
+	}
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if len(s) <= 10 {
+			ts *= 1000
+		}
+		return ts, nil
+	}
+	t, err := time.Parse(im.config.TimeLayout, s)
+	if err != nil {
+		return 0, invalid("time", "column [%s] must be a timestamp or match %q. [value=%s]", im.config.TimeColumn, im.config.TimeLayout, s)
+	}
+	return t.UnixNano() / int64(time.Millisecond), nil
+}
+
+// records 返回逐行读取数据的函数，读取完毕时返回 io.EOF，单行格式有误时返回该行的错误
+func (im *Importer) records(r io.Reader) (func() (map[string]interface{}, error), error) {
+	if im.config.Format == ImportCSV {
+		return im.csvRecords(r)
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	return func() (map[string]interface{}, error) {
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			decoder := json.NewDecoder(bytes.NewReader(line))
+			decoder.UseNumber()
+			var record map[string]interface{}
+			if err := decoder.Decode(&record); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrIllegalDataException, err)
+			}
+			return record, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}, nil
+}
+
+func (im *Importer) csvRecords(r io.Reader) (func() (map[string]interface{}, error), error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return func() (map[string]interface{}, error) { return nil, io.EOF }, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() (map[string]interface{}, error) {
+		fields, err := reader.Read()
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return nil, fmt.Errorf("%w: %s", ErrIllegalDataException, err)
+			}
+			return nil, err
+		}
+		if len(fields) != len(header) {
+			return nil, fmt.Errorf("%w: expected %d columns, got %d", ErrIllegalDataException, len(header), len(fields))
+		}
+		record := make(map[string]interface{}, len(header))
+		for i, column := range header {
+			if fields[i] == "" {
+				continue
+			}
+			value, err := im.convert(column, fields[i])
+			if err != nil {
+				return nil, err
+			}
+			record[column] = value
+		}
+		return record, nil
+	}, nil
+}
+
+// convert 按 Types 转换 CSV 中的值
+func (im *Importer) convert(column string, value string) (interface{}, error) {
+	switch im.config.Types[column] {
+	case ColumnNumber:
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, invalid(column, "column [%s] must be a number. [value=%s]", column, value)
+		}
+		return f, nil
+	case ColumnBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, invalid(column, "column [%s] must be a bool. [value=%s]", column, value)
+		}
+		return b, nil
+	case ColumnList:
+		return strings.Split(value, im.config.ListSeparator), nil
+	default:
+		return value, nil
+	}
+}
+
+// loadCheckpoint 返回已导入的行数，进度文件不存在时返回 0
+func (im *Importer) loadCheckpoint() (int, error) {
+	if im.config.CheckpointPath == "" {
+		return 0, nil
+	}
+	b, err := ioutil.ReadFile(im.config.CheckpointPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var cp importCheckpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return 0, fmt.Errorf("checkpoint %s: %s", im.config.CheckpointPath, err)
+	}
+	return cp.Row, nil
+}
+
+// checkpoint 发送已缓存的数据，Flush 返回即表示数据已发送，成功后将进度保存为 row，返回 cause 或保存时的错误
+func (im *Importer) checkpoint(row int, cause error) error {
+	if err := im.client.Flush(); err != nil {
+		// 数据未能发送，不保存进度，下次从上一个进度继续导入
+		if cause != nil {
+			return cause
+		}
+		return err
+	}
+	if im.config.CheckpointPath != "" {
+		if err := saveCheckpoint(im.config.CheckpointPath, importCheckpoint{Row: row}); err != nil && cause == nil {
+			return err
+		}
+	}
+	return cause
+}
+
+func saveCheckpoint(path string, cp importCheckpoint) error {
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	return atomicfile.Write(path, b)
+}
+
+// waitUntil 等待到 t，ctx 取消时提前返回
+func waitUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,67 @@
+package sensorsanalytics_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/satest"
+)
+
+func TestImporterRejectsAsyncConsumer(t *testing.T) {
+	consumer, err := sa.NewAsyncBatchConsumer("http://127.0.0.1:1/sa", 50, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+	if _, err := sa.NewImporter(consumer, "default", sa.ImportConfig{}); err == nil {
+		t.Fatal("NewImporter(AsyncBatchConsumer): want error")
+	}
+}
+
+func TestImporterCheckpointFollowsDelivery(t *testing.T) {
+	collector := satest.NewCollector()
+	defer collector.Close()
+	checkpoint := filepath.Join(t.TempDir(), "import.checkpoint")
+	var rows strings.Builder
+	for _, id := range []string{"u1", "u2", "u3", "u4"} {
+		rows.WriteString(`{"distinct_id":"` + id + `","event":"Buy","time":1600000000000}` + "\n")
+	}
+	run := func() (sa.ImportResult, error) {
+		consumer, err := sa.NewBatchConsumer(collector.URL(), 50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		im, err := sa.NewImporter(consumer, "default", sa.ImportConfig{CheckpointPath: checkpoint, CheckpointEvery: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return im.Import(context.Background(), strings.NewReader(rows.String()))
+	}
+
+	collector.FailNext(1, 500)
+	if _, err := run(); err == nil {
+		t.Fatal("first import: want send error")
+	}
+	if _, err := os.Stat(checkpoint); !os.IsNotExist(err) {
+		t.Fatalf("checkpoint saved although nothing was delivered: %v", err)
+	}
+
+	result, err := run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Imported != 4 || result.Skipped != 0 {
+		t.Fatalf("resumed import: %+v", result)
+	}
+	seen := map[string]bool{}
+	for _, e := range collector.Events() {
+		seen[e.DistinctID] = true
+	}
+	if len(seen) != 4 {
+		t.Fatalf("delivered users %v, want u1..u4", seen)
+	}
+}
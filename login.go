@@ -0,0 +1,319 @@
+package sensorsanalytics
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/internal/atomicfile"
+)
+
+// DefaultSignupStoreCapacity Client 默认使用的 MemorySignupStore 最多记录的关联数
+const DefaultSignupStoreCapacity = 100000
+
+// SignupStore 记录已发送过 $SignUp 的匿名 ID 与登录 ID，Login 据此避免重复关联
+type SignupStore interface {
+	// Add 记录 anonymousID 与 loginID 已关联，之前未记录时返回 true
+	Add(anonymousID string, loginID string) (bool, error)
+	// Remove 删除记录，$SignUp 发送失败时调用，下次 Login 时重新发送
+	Remove(anonymousID string, loginID string) error
+}
+
+func signupKey(anonymousID string, loginID string) string {
+	return anonymousID + "\x00" + loginID
+}
+
+// MemorySignupStore 保存在内存中的 SignupStore，超过容量时删除最早的记录。
+// 被删除的关联再次 Login 时会重复发送 $SignUp，服务器会忽略重复的关联。
+type MemorySignupStore struct {
+	lock     sync.Mutex
+	capacity int
+	seen     map[string]bool
+	order    []string
+}
+
+// NewMemorySignupStore 创建新的 MemorySignupStore
+// :param capacity: 最多记录的关联数，为 0 时不限制
+func NewMemorySignupStore(capacity int) *MemorySignupStore {
+	return &MemorySignupStore{capacity: capacity, seen: map[string]bool{}}
+}
+
+// Add 记录 anonymousID 与 loginID 已关联，之前未记录时返回 true
+func (s *MemorySignupStore) Add(anonymousID string, loginID string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.add(signupKey(anonymousID, loginID)), nil
+}
+
+// Remove 删除记录，$SignUp 发送失败时调用，下次 Login 时重新发送
+func (s *MemorySignupStore) Remove(anonymousID string, loginID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.remove(signupKey(anonymousID, loginID))
+	return nil
+}
+
+// Len 返回记录的关联数
+func (s *MemorySignupStore) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.seen)
+}
+
+func (s *MemorySignupStore) add(key string) bool {
+	if s.seen[key] {
+		return false
+	}
+	s.seen[key] = true
+	if s.capacity <= 0 {
+		return true
+	}
+	s.order = append(s.order, key)
+	for len(s.seen) > s.capacity && len(s.order) > 0 {
+		oldest := s.order[0]
+		s.order = s.order[1:]
+		delete(s.seen, oldest)
+	}
+	return true
+}
+
+func (s *MemorySignupStore) remove(key string) {
+	if !s.seen[key] {
+		return
+	}
+	delete(s.seen, key)
+	for i, k := range s.order {
+		if k == key {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// FileSignupStore 保存在文件中的 SignupStore，进程重启后仍然有效。
+// 每次修改只在文件末尾追加一行，记录数超过容量的两倍时重写文件删除已淘汰的记录。
+// 追加后不调用 fsync，断电时可能丢失最近的记录，只会导致重复发送 $SignUp。
+type FileSignupStore struct {
+	MemorySignupStore
+	path string
+	file *os.File
+	// lines 文件中的行数，包括已被淘汰或删除的记录
+	lines int
+}
+
+// signupRecord FileSignupStore 文件中的一行
+type signupRecord struct {
+	AnonymousID string `json:"anonymous_id"`
+	LoginID     string `json:"login_id"`
+	// Removed 为 true 时表示删除该记录
+	Removed bool `json:"removed,omitempty"`
+}
+
+// NewFileSignupStore 创建新的 FileSignupStore，文件存在时从中读取已有记录，使用完毕后需调用 Close
+// :param path: 文件路径
+// :param capacity: 最多记录的关联数，超过时删除最早的记录，不大于 0 时为 DefaultSignupStoreCapacity
+func NewFileSignupStore(path string, capacity int) (*FileSignupStore, error) {
+	if capacity <= 0 {
+		capacity = DefaultSignupStoreCapacity
+	}
+	s := &FileSignupStore{path: path}
+	s.capacity = capacity
+	s.seen = map[string]bool{}
+	f, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			s.lines++
+			var r signupRecord
+			// 进程中断时最后一行可能不完整，跳过无法解析的行
+			if json.Unmarshal(scanner.Bytes(), &r) != nil {
+				continue
+			}
+			if r.Removed {
+				s.remove(signupKey(r.AnonymousID, r.LoginID))
+			} else {
+				s.add(signupKey(r.AnonymousID, r.LoginID))
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.lines > len(s.seen) {
+		// 文件中有已淘汰或删除的记录，重写后再追加
+		if err := s.compact(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	if s.file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Add 记录 anonymousID 与 loginID 已关联，之前未记录时返回 true
+func (s *FileSignupStore) Add(anonymousID string, loginID string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := signupKey(anonymousID, loginID)
+	if !s.add(key) {
+		return false, nil
+	}
+	if err := s.append(signupRecord{AnonymousID: anonymousID, LoginID: loginID}); err != nil {
+		s.remove(key)
+		return false, err
+	}
+	return true, nil
+}
+
+// Remove 删除记录，$SignUp 发送失败时调用，下次 Login 时重新发送
+func (s *FileSignupStore) Remove(anonymousID string, loginID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	key := signupKey(anonymousID, loginID)
+	if !s.seen[key] {
+		return nil
+	}
+	s.remove(key)
+	return s.append(signupRecord{AnonymousID: anonymousID, LoginID: loginID, Removed: true})
+}
+
+// Close 关闭文件
+func (s *FileSignupStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// append 在文件末尾追加一行，行数超过容量的两倍时重写文件，调用时需持有锁
+func (s *FileSignupStore) append(r signupRecord) error {
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	s.lines++
+	if s.lines > 2*s.capacity {
+		// 记录已经写入，重写失败时下次追加后再重试
+		if err := s.compact(); err != nil {
+			log.Printf("FileSignupStore compact %s: %s", s.path, err)
+		}
+	}
+	return nil
+}
+
+// compact 按记录的先后顺序原子重写文件，只保留当前的记录，调用时需持有锁
+func (s *FileSignupStore) compact() error {
+	var buf bytes.Buffer
+	for _, key := range s.order {
+		anonymousID, loginID := splitSignupKey(key)
+		b, err := json.Marshal(signupRecord{AnonymousID: anonymousID, LoginID: loginID})
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	if err := atomicfile.Write(s.path, buf.Bytes()); err != nil {
+		return err
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		s.file = nil
+		return err
+	}
+	s.file = f
+	s.lines = len(s.order)
+	return nil
+}
+
+func splitSignupKey(key string) (string, string) {
+	i := strings.IndexByte(key, 0)
+	return key[:i], key[i+1:]
+}
+
+// SetSignupStore 设置 Login 使用的 SignupStore，为 nil 时每次 Login 都发送 $SignUp。
+// 默认使用容量为 DefaultSignupStoreCapacity 的 MemorySignupStore，
+// 多个进程共用同一批用户时可以实现基于 Redis 等的 SignupStore。
+func (c *Client) SetSignupStore(store SignupStore) {
+	c.signupStore = store
+}
+
+// Login 关联用户登录前的匿名 ID 与登录 ID，返回绑定登录 ID 的 BoundClient。
+// 每对 ID 只发送一次 $SignUp，已关联过时只返回 BoundClient；两个 ID 相同时不发送 $SignUp。
+// 登录 ID 撤回了同意时不发送 $SignUp，也不记录到 SignupStore。
+// :param anonymousID: 用户登录前的匿名 ID，例如客户端 SDK 的匿名 ID
+// :param loginID: 用户的登录 ID
+// :param properties: $SignUp 事件的属性
+func (c *Client) Login(anonymousID string, loginID string, properties map[string]interface{}) (*BoundClient, error) {
+	if err := checkLoginID("original_id", anonymousID); err != nil {
+		return nil, err
+	}
+	if err := checkLoginID("distinct_id", loginID); err != nil {
+		return nil, err
+	}
+	bound := c.Bind(loginID, true)
+	if anonymousID == loginID {
+		return bound, nil
+	}
+	// 先检查同意记录，未发送的 $SignUp 不记录到 SignupStore，重新同意后 Login 会再次发送
+	optedOut, err := c.optedOut("track_signup", "$SignUp", loginID)
+	if err != nil {
+		return nil, err
+	}
+	if optedOut {
+		atomic.AddInt64(&c.suppressed, 1)
+		return bound, nil
+	}
+	if c.signupStore != nil {
+		added, err := c.signupStore.Add(anonymousID, loginID)
+		if err != nil {
+			return nil, err
+		}
+		if !added {
+			return bound, nil
+		}
+	}
+	allProperties := c.mergeSuperProperties(properties)
+	if err := c.sendEvent("track_signup", "$SignUp", loginID, anonymousID, allProperties, true); err != nil {
+		if c.signupStore != nil {
+			c.signupStore.Remove(anonymousID, loginID)
+		}
+		return nil, err
+	}
+	return bound, nil
+}
+
+func checkLoginID(field string, id string) error {
+	if len(id) == 0 {
+		return invalid(field, "property [%s] must not be empty", field)
+	}
+	if len(id) > 255 {
+		return invalid(field, "the max length of property [%s] is 255", field)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package sensorsanalytics_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/satest"
+)
+
+func TestLogin(t *testing.T) {
+	rec := satest.NewRecordingConsumer()
+	clt, _ := sa.NewClient(rec, "default", false)
+	user, err := clt.Login("anon", "user", map[string]interface{}{"from": "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.DistinctID() != "user" || !user.IsLoginID() {
+		t.Fatalf("bound to %q, login %v", user.DistinctID(), user.IsLoginID())
+	}
+	if _, err := clt.Login("anon", "user", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clt.Login("same", "same", nil); err != nil {
+		t.Fatal(err)
+	}
+	signups := rec.OfType("track_signup")
+	if len(signups) != 1 {
+		t.Fatalf("sent %d $SignUp, want 1", len(signups))
+	}
+	if e := signups[0]; e.OriginalID != "anon" || e.DistinctID != "user" || e.Properties["$is_login_id"] != true {
+		t.Errorf("$SignUp: %+v", e)
+	}
+
+	tests := []struct {
+		anonymousID, loginID, field string
+	}{
+		{"", "user", "original_id"},
+		{strings.Repeat("a", 256), "user", "original_id"},
+		{"anon", "", "distinct_id"},
+		{"anon", strings.Repeat("u", 256), "distinct_id"},
+	}
+	for _, tt := range tests {
+		_, err := clt.Login(tt.anonymousID, tt.loginID, nil)
+		var verr *sa.ValidationError
+		if !errors.As(err, &verr) || verr.Field != tt.field {
+			t.Errorf("Login(%d chars, %d chars): got %v, want error on %s", len(tt.anonymousID), len(tt.loginID), err, tt.field)
+		}
+	}
+}
+
+func TestLoginRetriesAfterSendFailure(t *testing.T) {
+	rec := satest.NewRecordingConsumer()
+	clt, _ := sa.NewClient(rec, "default", false)
+	rec.SetError(sa.ErrNetworkException)
+	if _, err := clt.Login("anon", "user", nil); err == nil {
+		t.Fatal("want send error")
+	}
+	rec.SetError(nil)
+	if _, err := clt.Login("anon", "user", nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(rec.OfType("track_signup")); n != 1 {
+		t.Fatalf("sent %d $SignUp after retry, want 1", n)
+	}
+}
+
+func TestFileSignupStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signup.jsonl")
+	store, err := sa.NewFileSignupStore(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if added, err := store.Add(fmt.Sprintf("a%d", i), "u"); !added || err != nil {
+			t.Fatalf("Add a%d: %v %v", i, added, err)
+		}
+	}
+	if err := store.Remove("a9", "u"); err != nil {
+		t.Fatal(err)
+	}
+	if store.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", store.Len())
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if lines := countLines(t, path); lines > 2*3 {
+		t.Errorf("file has %d lines, want at most %d", lines, 2*3)
+	}
+
+	store, err = sa.NewFileSignupStore(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, tt := range []struct {
+		anonymousID string
+		wantAdded   bool
+	}{
+		{"a7", false},
+		{"a8", false},
+		{"a9", true},
+		{"a0", true},
+	} {
+		if added, err := store.Add(tt.anonymousID, "u"); added != tt.wantAdded || err != nil {
+			t.Errorf("reopened Add(%s) = %v, %v, want %v", tt.anonymousID, added, err, tt.wantAdded)
+		}
+	}
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n := 0
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		n++
+	}
+	return n
+}
+
+func TestLoginOptedOut(t *testing.T) {
+	rec := satest.NewRecordingConsumer()
+	clt, _ := sa.NewClient(rec, "default", false)
+	consent := sa.NewMemoryConsentStore()
+	clt.SetConsentStore(consent, nil)
+	signups := sa.NewMemorySignupStore(0)
+	clt.SetSignupStore(signups)
+
+	consent.OptOut("user")
+	user, err := clt.Login("anon", "user", nil)
+	if err != nil || user.DistinctID() != "user" {
+		t.Fatalf("Login() = %v, %v", user, err)
+	}
+	if n := len(rec.OfType("track_signup")); n != 0 || clt.SuppressedCount() != 1 {
+		t.Fatalf("sent %d $SignUp, suppressed %d, want 0 and 1", n, clt.SuppressedCount())
+	}
+	if signups.Len() != 0 {
+		t.Fatal("suppressed $SignUp was recorded in the SignupStore")
+	}
+
+	// 重新同意后再次 Login 发送 $SignUp
+	consent.OptIn("user")
+	if _, err := clt.Login("anon", "user", nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(rec.OfType("track_signup")); n != 1 {
+		t.Errorf("sent %d $SignUp after opting in, want 1", n)
+	}
+}
@@ -0,0 +1,163 @@
+package sensorsanalytics
+
+import (
+	"sync"
+	"time"
+)
+
+// Middleware 包装 Consumer，在数据发送前对完整的数据 (包括 lib、project、time_free 等字段) 进行处理，
+// 可以修改、过滤数据，或不调用 next 直接返回
+type Middleware func(next Consumer) Consumer
+
+// Chain 用 middlewares 依次包装 consumer，第一个 Middleware 最先处理数据
+func Chain(consumer Consumer, middlewares ...Middleware) Consumer {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		consumer = middlewares[i](consumer)
+	}
+	return consumer
+}
+
+// WrapSend 返回使用 send 发送数据的 Consumer，Flush 和 Close 直接调用 next
+func WrapSend(next Consumer, send func(msg map[string]interface{}) error) Consumer {
+	return &sendWrapper{next: next, send: send}
+}
+
+type sendWrapper struct {
+	next Consumer
+	send func(msg map[string]interface{}) error
+}
+
+func (w *sendWrapper) Send(msg map[string]interface{}) error {
+	return w.send(msg)
+}
+
+func (w *sendWrapper) Flush() error {
+	return w.next.Flush()
+}
+
+func (w *sendWrapper) Close() error {
+	return w.next.Close()
+}
+
+func (w *sendWrapper) Purge(distinctID string) int {
+	return purge(w.next, distinctID)
+}
+
+// FilterMiddleware 只发送 keep 返回 true 的数据，其余数据被丢弃且不返回错误
+func FilterMiddleware(keep func(msg map[string]interface{}) bool) Middleware {
+	return func(next Consumer) Consumer {
+		return WrapSend(next, func(msg map[string]interface{}) error {
+			if !keep(msg) {
+				return nil
+			}
+			return next.Send(msg)
+		})
+	}
+}
+
+// RewritePropertiesMiddleware 在发送前调用 rewrite 修改数据的 properties
+func RewritePropertiesMiddleware(rewrite func(msg map[string]interface{}, properties map[string]interface{})) Middleware {
+	return func(next Consumer) Consumer {
+		return WrapSend(next, func(msg map[string]interface{}) error {
+			if properties, ok := msg["properties"].(map[string]interface{}); ok {
+				rewrite(msg, properties)
+			}
+			return next.Send(msg)
+		})
+	}
+}
+
+// EnrichMiddleware 为 track 数据添加 properties 中不存在的属性，profile 数据不受影响
+func EnrichMiddleware(enrich func(msg map[string]interface{}) map[string]interface{}) Middleware {
+	return RewritePropertiesMiddleware(func(msg map[string]interface{}, properties map[string]interface{}) {
+		if msgType, _ := msg["type"].(string); msgType != "track" && msgType != "track_signup" {
+			return
+		}
+		for k, v := range enrich(msg) {
+			if _, ok := properties[k]; !ok {
+				properties[k] = v
+			}
+		}
+	})
+}
+
+// Metrics MetricsMiddleware 记录的发送统计
+type Metrics struct {
+	lock    sync.Mutex
+	sent    int64
+	failed  int64
+	latency time.Duration
+	types   map[string]int64
+	events  map[string]int64
+}
+
+// MetricsSnapshot 某一时刻的发送统计
+type MetricsSnapshot struct {
+	// Sent 发送成功的数据条数
+	Sent int64
+	// Failed 发送失败的数据条数
+	Failed int64
+	// Latency 调用 next.Send 的总耗时
+	Latency time.Duration
+	// Types 按数据类型统计的条数
+	Types map[string]int64
+	// Events 按事件名称统计的条数
+	Events map[string]int64
+}
+
+// NewMetrics 创建新的 Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		types:  map[string]int64{},
+		events: map[string]int64{},
+	}
+}
+
+// Snapshot 返回当前的发送统计
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	snapshot := MetricsSnapshot{
+		Sent:    m.sent,
+		Failed:  m.failed,
+		Latency: m.latency,
+		Types:   make(map[string]int64, len(m.types)),
+		Events:  make(map[string]int64, len(m.events)),
+	}
+	for k, v := range m.types {
+		snapshot.Types[k] = v
+	}
+	for k, v := range m.events {
+		snapshot.Events[k] = v
+	}
+	return snapshot
+}
+
+func (m *Metrics) record(msg map[string]interface{}, latency time.Duration, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err != nil {
+		m.failed++
+	} else {
+		m.sent++
+	}
+	m.latency += latency
+	if msgType, ok := msg["type"].(string); ok {
+		m.types[msgType]++
+	}
+	if event, ok := msg["event"].(string); ok {
+		m.events[event]++
+	}
+}
+
+// MetricsMiddleware 将发送结果记录到 metrics
+func MetricsMiddleware(metrics *Metrics) Middleware {
+	return func(next Consumer) Consumer {
+		return WrapSend(next, func(msg map[string]interface{}) error {
+			start := time.Now()
+			err := next.Send(msg)
+			metrics.record(msg, time.Since(start), err)
+			return err
+		})
+	}
+}
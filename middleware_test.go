@@ -0,0 +1,159 @@
+package sensorsanalytics_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/satest"
+)
+
+func newMiddlewareClient(t *testing.T, middlewares ...sa.Middleware) (*sa.Client, *satest.RecordingConsumer) {
+	t.Helper()
+	rec := satest.NewRecordingConsumer()
+	client, err := sa.NewClient(rec, "default", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Use(middlewares...)
+	return client, rec
+}
+
+// traceMiddleware 将 name 追加到 trace 属性中
+func traceMiddleware(name string) sa.Middleware {
+	return sa.RewritePropertiesMiddleware(func(msg map[string]interface{}, properties map[string]interface{}) {
+		trace, _ := properties["trace"].(string)
+		properties["trace"] = strings.TrimPrefix(trace+","+name, ",")
+	})
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	client, rec := newMiddlewareClient(t, traceMiddleware("a"), traceMiddleware("b"))
+	client.Use(traceMiddleware("c"))
+	if err := client.Track("u1", "PageView", nil, false); err != nil {
+		t.Fatal(err)
+	}
+	satest.AssertTracked(t, rec, "PageView", map[string]interface{}{"trace": "a,b,c"})
+
+	// Chain 单独使用时顺序相同
+	rec.Reset()
+	consumer := sa.Chain(rec, traceMiddleware("x"), traceMiddleware("y"))
+	if err := consumer.Send(trackMsg("u1", nil)); err != nil {
+		t.Fatal(err)
+	}
+	satest.AssertTracked(t, rec, "OrderPaid", map[string]interface{}{"trace": "x,y"})
+}
+
+func TestMiddlewareMutatesEnvelope(t *testing.T) {
+	setProject := func(next sa.Consumer) sa.Consumer {
+		return sa.WrapSend(next, func(msg map[string]interface{}) error {
+			msg["project"] = "staging"
+			return next.Send(msg)
+		})
+	}
+	enrich := sa.EnrichMiddleware(func(msg map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"region": "cn", "plan": "free"}
+	})
+	client, rec := newMiddlewareClient(t, setProject, enrich)
+	client.Track("u1", "PageView", map[string]interface{}{"plan": "pro"}, false)
+	client.ProfileSet("u1", map[string]interface{}{"name": "a"}, false)
+
+	envelopes := rec.Envelopes()
+	if len(envelopes) != 2 {
+		t.Fatalf("recorded %d messages, want 2", len(envelopes))
+	}
+	track, profile := envelopes[0], envelopes[1]
+	if track.Project != "staging" || profile.Project != "staging" {
+		t.Errorf("projects %q and %q, want staging", track.Project, profile.Project)
+	}
+	// EnrichMiddleware 不覆盖已有属性，也不修改 profile 数据
+	if track.Properties["region"] != "cn" || track.Properties["plan"] != "pro" {
+		t.Errorf("track properties %v, want region added and plan kept", track.Properties)
+	}
+	if _, ok := profile.Properties["region"]; ok {
+		t.Errorf("profile properties %v were enriched", profile.Properties)
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	errBlocked := errors.New("blocked")
+	reached := false
+	block := func(next sa.Consumer) sa.Consumer {
+		return sa.WrapSend(next, func(msg map[string]interface{}) error {
+			if msg["event"] == "Blocked" {
+				return errBlocked
+			}
+			return next.Send(msg)
+		})
+	}
+	after := func(next sa.Consumer) sa.Consumer {
+		return sa.WrapSend(next, func(msg map[string]interface{}) error {
+			reached = true
+			return next.Send(msg)
+		})
+	}
+	drop := sa.FilterMiddleware(func(msg map[string]interface{}) bool {
+		return msg["event"] != "Dropped"
+	})
+	client, rec := newMiddlewareClient(t, block, drop, after)
+
+	if err := client.Track("u1", "Blocked", nil, false); err != errBlocked {
+		t.Errorf("Track() = %v, want the middleware's error", err)
+	}
+	if err := client.Track("u1", "Dropped", nil, false); err != nil {
+		t.Errorf("Track() = %v, want filtered messages to be dropped without an error", err)
+	}
+	if reached {
+		t.Error("later middleware was called after the chain stopped")
+	}
+	satest.AssertNoEvents(t, rec)
+	if err := client.Track("u1", "PageView", nil, false); err != nil || !reached {
+		t.Errorf("Track() = %v, reached %v, want the message to pass every middleware", err, reached)
+	}
+}
+
+func TestMiddlewarePassesFlushClosePurge(t *testing.T) {
+	client, rec := newMiddlewareClient(t, traceMiddleware("a"), sa.FilterMiddleware(func(map[string]interface{}) bool { return true }))
+	client.Track("u1", "PageView", nil, false)
+	client.Track("u2", "PageView", nil, false)
+
+	if err := client.Flush(); err != nil || rec.Flushes() != 1 {
+		t.Errorf("Flush() = %v, consumer flushed %d times, want 1", err, rec.Flushes())
+	}
+	// ForgetUser 经过 Middleware 删除 Consumer 中的数据
+	if err := client.ForgetUser("u1"); err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	for _, e := range rec.Envelopes() {
+		left = append(left, e.Type+":"+e.DistinctID)
+	}
+	if strings.Join(left, " ") != "track:u2 profile_delete:u1" {
+		t.Errorf("messages after ForgetUser %v, want u1's event purged", left)
+	}
+	if err := client.Close(); err != nil || rec.Closes() != 1 {
+		t.Errorf("Close() = %v, consumer closed %d times, want 1", err, rec.Closes())
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	metrics := sa.NewMetrics()
+	client, rec := newMiddlewareClient(t, sa.MetricsMiddleware(metrics))
+	client.Track("u1", "PageView", nil, false)
+	client.Track("u1", "PageView", nil, false)
+	client.ProfileSet("u1", map[string]interface{}{"name": "a"}, false)
+	rec.SetError(errors.New("down"))
+	client.Track("u1", "OrderPaid", nil, false)
+
+	snapshot := metrics.Snapshot()
+	if snapshot.Sent != 3 || snapshot.Failed != 1 {
+		t.Errorf("sent %d, failed %d, want 3 and 1", snapshot.Sent, snapshot.Failed)
+	}
+	if snapshot.Types["track"] != 3 || snapshot.Types["profile_set"] != 1 {
+		t.Errorf("types %v", snapshot.Types)
+	}
+	if snapshot.Events["PageView"] != 2 || snapshot.Events["OrderPaid"] != 1 {
+		t.Errorf("events %v", snapshot.Events)
+	}
+}
@@ -0,0 +1,162 @@
+package sensorsanalytics
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// MultiMode MultiConsumer 判断发送是否成功的方式
+type MultiMode int
+
+const (
+	// MultiAll 所有 Consumer 都成功才算成功，返回所有失败的 Consumer 的错误
+	MultiAll MultiMode = iota
+	// MultiBestEffort 至少一个 Consumer 成功即算成功，全部失败时返回所有错误
+	MultiBestEffort
+	// MultiPrimary 第一个 Consumer 为主，其余为镜像，只返回主 Consumer 的错误
+	MultiPrimary
+)
+
+// ConsumerError 单个 Consumer 返回的错误
+type ConsumerError struct {
+	// Index Consumer 在 MultiConsumer 中的位置，或在 RoutingConsumer 去重后的规则中的位置
+	Index int
+	Err   error
+}
+
+// MultiError 按 Consumer 汇总的错误
+type MultiError struct {
+	Errors []ConsumerError
+}
+
+func (e *MultiError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, ce := range e.Errors {
+		msgs = append(msgs, fmt.Sprintf("consumer[%d]: %s", ce.Index, ce.Err))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is 任一 Consumer 的错误为 target 时返回 true，以便使用 errors.Is 判断
+func (e *MultiError) Is(target error) bool {
+	for _, ce := range e.Errors {
+		if errors.Is(ce.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As 将第一个可以转换为 target 的 Consumer 错误赋值给 target，以便使用 errors.As 判断
+func (e *MultiError) As(target interface{}) bool {
+	for _, ce := range e.Errors {
+		if errors.As(ce.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// MultiConsumer 将每条数据同时发送给多个 Consumer，例如同时发送到服务器并写入本地文件
+type MultiConsumer struct {
+	mode         MultiMode
+	consumers    []Consumer
+	errorHandler func(err *MultiError)
+}
+
+// NewMultiConsumer 创建新的 MultiConsumer
+// :param mode: 判断发送是否成功的方式
+// :param consumers: 接收数据的 Consumer，MultiPrimary 模式下第一个为主 Consumer
+func NewMultiConsumer(mode MultiMode, consumers ...Consumer) (*MultiConsumer, error) {
+	var c MultiConsumer
+	if len(consumers) == 0 {
+		return &c, errors.New("consumers must not be empty")
+	}
+	c.mode = mode
+	c.consumers = consumers
+	return &c, nil
+}
+
+// SetErrorHandler 设置未被返回的错误 (例如镜像 Consumer 的错误) 的处理函数，默认输出到日志
+func (c *MultiConsumer) SetErrorHandler(handler func(err *MultiError)) {
+	c.errorHandler = handler
+}
+
+// Send 发送数据
+func (c *MultiConsumer) Send(msg map[string]interface{}) error {
+	return c.each(func(consumer Consumer) error {
+		return consumer.Send(msg)
+	})
+}
+
+// Flush 对所有 Consumer 调用 Flush
+func (c *MultiConsumer) Flush() error {
+	return c.each(func(consumer Consumer) error {
+		return consumer.Flush()
+	})
+}
+
+// Close 对所有 Consumer 调用 Close
+func (c *MultiConsumer) Close() error {
+	return c.each(func(consumer Consumer) error {
+		return consumer.Close()
+	})
+}
+
+// Purge 删除所有 Consumer 中指定用户尚未发送的数据
+func (c *MultiConsumer) Purge(distinctID string) int {
+	purged := 0
+	for _, consumer := range c.consumers {
+		purged += purge(consumer, distinctID)
+	}
+	return purged
+}
+
+// each 对每个 Consumer 执行 fn，按 mode 决定返回的错误
+func (c *MultiConsumer) each(fn func(consumer Consumer) error) error {
+	var errs []ConsumerError
+	for i, consumer := range c.consumers {
+		if err := fn(consumer); err != nil {
+			errs = append(errs, ConsumerError{Index: i, Err: err})
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	var returned, handled []ConsumerError
+	switch c.mode {
+	case MultiAll:
+		returned = errs
+	case MultiBestEffort:
+		if len(errs) == len(c.consumers) {
+			returned = errs
+		} else {
+			handled = errs
+		}
+	case MultiPrimary:
+		for _, ce := range errs {
+			if ce.Index == 0 {
+				returned = append(returned, ce)
+			} else {
+				handled = append(handled, ce)
+			}
+		}
+	}
+	if len(handled) > 0 {
+		c.handle(&MultiError{Errors: handled})
+	}
+	if len(returned) == 0 {
+		return nil
+	}
+	return &MultiError{Errors: returned}
+}
+
+func (c *MultiConsumer) handle(err *MultiError) {
+	if c.errorHandler != nil {
+		c.errorHandler(err)
+		return
+	}
+	log.Printf("MultiConsumer: %s", err)
+}
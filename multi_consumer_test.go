@@ -0,0 +1,201 @@
+package sensorsanalytics_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+// recordingConsumer 记录收到的数据，err 不为 nil 时 Send 返回该错误
+type recordingConsumer struct {
+	lock    sync.Mutex
+	err     error
+	msgs    []map[string]interface{}
+	flushes int
+	closes  int
+}
+
+func (c *recordingConsumer) Send(msg map[string]interface{}) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.msgs = append(c.msgs, msg)
+	return nil
+}
+
+func (c *recordingConsumer) Flush() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.flushes++
+	return nil
+}
+
+func (c *recordingConsumer) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closes++
+	return nil
+}
+
+func (c *recordingConsumer) Purge(distinctID string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	kept := c.msgs[:0]
+	for _, msg := range c.msgs {
+		if msg["distinct_id"] != distinctID {
+			kept = append(kept, msg)
+		}
+	}
+	purged := len(c.msgs) - len(kept)
+	c.msgs = kept
+	return purged
+}
+
+// ofType 返回类型为 msgType 的数据
+func (c *recordingConsumer) ofType(msgType string) []map[string]interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var msgs []map[string]interface{}
+	for _, msg := range c.msgs {
+		if msg["type"] == msgType {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+func TestMultiConsumerModes(t *testing.T) {
+	errDown := errors.New("down")
+	tests := []struct {
+		name string
+		mode sa.MultiMode
+		// failing 发送失败的 Consumer 位置
+		failing     []int
+		wantErr     []int
+		wantHandled []int
+	}{
+		{"all ok", sa.MultiAll, nil, nil, nil},
+		{"all one fails", sa.MultiAll, []int{1}, []int{1}, nil},
+		{"best effort one fails", sa.MultiBestEffort, []int{0}, nil, []int{0}},
+		{"best effort all fail", sa.MultiBestEffort, []int{0, 1, 2}, []int{0, 1, 2}, nil},
+		{"primary mirror fails", sa.MultiPrimary, []int{1, 2}, nil, []int{1, 2}},
+		{"primary fails", sa.MultiPrimary, []int{0, 2}, []int{0}, []int{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recs := []*recordingConsumer{{}, {}, {}}
+			for _, i := range tt.failing {
+				recs[i].err = errDown
+			}
+			multi, err := sa.NewMultiConsumer(tt.mode, recs[0], recs[1], recs[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			var handled []int
+			multi.SetErrorHandler(func(err *sa.MultiError) {
+				handled = append(handled, indexes(err)...)
+			})
+
+			err = multi.Send(trackMsg("u1", nil))
+			var multiErr *sa.MultiError
+			if errors.As(err, &multiErr) {
+				if got := indexes(multiErr); !equalInts(got, tt.wantErr) {
+					t.Errorf("returned errors for %v, want %v", got, tt.wantErr)
+				}
+				if !errors.Is(err, errDown) {
+					t.Errorf("errors.Is(%v, errDown) = false", err)
+				}
+			} else if err != nil || len(tt.wantErr) > 0 {
+				t.Errorf("Send() = %v, want errors for %v", err, tt.wantErr)
+			}
+			if !equalInts(handled, tt.wantHandled) {
+				t.Errorf("handled errors for %v, want %v", handled, tt.wantHandled)
+			}
+			for i, rec := range recs {
+				if want := !contains(tt.failing, i); (len(rec.msgs) == 1) != want {
+					t.Errorf("consumer %d recorded %d messages", i, len(rec.msgs))
+				}
+			}
+		})
+	}
+}
+
+func TestMultiConsumerPropagates(t *testing.T) {
+	recs := []*recordingConsumer{{}, {}}
+	multi, _ := sa.NewMultiConsumer(sa.MultiAll, recs[0], recs[1])
+	multi.Send(trackMsg("u1", nil))
+	multi.Send(trackMsg("u2", nil))
+	if err := multi.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := multi.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := multi.Purge("u1"); n != 2 {
+		t.Errorf("Purge(u1) = %d, want 2", n)
+	}
+	for i, rec := range recs {
+		if rec.flushes != 1 || rec.closes != 1 || len(rec.msgs) != 1 {
+			t.Errorf("consumer %d: %d flushes, %d closes, %d messages", i, rec.flushes, rec.closes, len(rec.msgs))
+		}
+	}
+	if _, err := sa.NewMultiConsumer(sa.MultiAll); err == nil {
+		t.Error("NewMultiConsumer without consumers succeeded")
+	}
+}
+
+func indexes(err *sa.MultiError) []int {
+	var idx []int
+	for _, ce := range err.Errors {
+		idx = append(idx, ce.Index)
+	}
+	return idx
+}
+
+func equalInts(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []int, v int) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMultiErrorIsAs(t *testing.T) {
+	statusErr := &sa.StatusError{StatusCode: 500}
+	err := error(&sa.MultiError{Errors: []sa.ConsumerError{
+		{Index: 0, Err: fmt.Errorf("send: %w", sa.ErrConsumerClosed)},
+		{Index: 1, Err: statusErr},
+	}})
+	if !errors.Is(err, sa.ErrConsumerClosed) {
+		t.Errorf("errors.Is(%v, ErrConsumerClosed) = false", err)
+	}
+	if errors.Is(err, sa.ErrNoRoute) {
+		t.Errorf("errors.Is(%v, ErrNoRoute) = true", err)
+	}
+	var target *sa.StatusError
+	if !errors.As(err, &target) || target != statusErr {
+		t.Errorf("errors.As(%v) = %v, want the consumer's StatusError", err, target)
+	}
+	var verr *sa.ValidationError
+	if errors.As(err, &verr) {
+		t.Errorf("errors.As(%v, *ValidationError) = true", err)
+	}
+}
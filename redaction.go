@@ -0,0 +1,290 @@
+package sensorsanalytics
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	// EmailPattern 邮箱地址
+	EmailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	// PhonePattern 中国大陆手机号码，前后不能紧接数字或字母，避免匹配订单号、时间戳等更长的数字串
+	PhonePattern = regexp.MustCompile(`(?:\+86|\b86|\b)1[3-9]\d{9}\b`)
+	// IDCardPattern 中国大陆居民身份证号码，前后不能紧接数字或字母
+	IDCardPattern = regexp.MustCompile(`\b\d{17}[\dXx]\b`)
+)
+
+// RedactAction 匹配到敏感信息时的处理方式
+type RedactAction int
+
+const (
+	// RedactDrop 删除属性
+	RedactDrop RedactAction = iota
+	// RedactMask 只保留最后 4 个字符，其余字符替换为 *
+	RedactMask
+	// RedactHash 替换为以 HashKey 计算的 HMAC-SHA256
+	RedactHash
+)
+
+func (a RedactAction) String() string {
+	switch a {
+	case RedactDrop:
+		return "drop"
+	case RedactMask:
+		return "mask"
+	case RedactHash:
+		return "hash"
+	}
+	return "unknown"
+}
+
+// RedactionRule 敏感信息的匹配规则。按属性名匹配时处理整个属性值；
+// 只按属性值匹配时，RedactMask 和 RedactHash 只替换匹配到的部分。
+// 按属性名匹配到的值不是字符串或字符串列表时 (例如数字形式的手机号码)，无法在不改变类型的情况下处理，
+// RedactMask 和 RedactHash 也会删除该属性。
+type RedactionRule struct {
+	// Name 规则名称，用于统计
+	Name string
+	// Keys 需要处理的属性名
+	Keys []string
+	// KeyPattern 需要处理的属性名的正则表达式
+	KeyPattern *regexp.Regexp
+	// ValuePattern 需要处理的属性值的正则表达式，只匹配字符串及字符串列表
+	ValuePattern *regexp.Regexp
+	Action       RedactAction
+}
+
+// RedactionPolicy 敏感信息处理策略，应用于事件属性、用户属性、distinct_id 及 original_id，
+// 在数据检查之前执行，处理后的数据仍需满足长度等限制。
+//
+// distinct_id 及 original_id 被 ValuePattern 匹配时，无论规则的 Action 如何都替换为以 HashKey 计算的
+// HMAC-SHA256：删除会丢失用户标识，掩码会使不同用户合并为同一用户，不带密钥的哈希可以通过穷举手机号码等还原。
+type RedactionPolicy struct {
+	Rules []RedactionRule
+	// HashKey HMAC 密钥，规则中有 RedactHash 或 ValuePattern 时不能为空
+	HashKey []byte
+}
+
+// PIIRules 返回匹配邮箱、手机号码及身份证号码的规则
+func PIIRules(action RedactAction) []RedactionRule {
+	return []RedactionRule{
+		{Name: "email", ValuePattern: EmailPattern, Action: action},
+		{Name: "phone", ValuePattern: PhonePattern, Action: action},
+		{Name: "id_card", ValuePattern: IDCardPattern, Action: action},
+	}
+}
+
+// RedactionAudit 敏感信息处理次数统计
+type RedactionAudit struct {
+	Total    int64
+	ByRule   map[string]int64
+	ByAction map[RedactAction]int64
+}
+
+type redactor struct {
+	policy RedactionPolicy
+	lock   sync.Mutex
+	audit  RedactionAudit
+}
+
+func newRedactor(policy RedactionPolicy) (*redactor, error) {
+	for i, rule := range policy.Rules {
+		if len(rule.Keys) == 0 && rule.KeyPattern == nil && rule.ValuePattern == nil {
+			return nil, fmt.Errorf("redaction rule [%d] must match keys or values", i)
+		}
+		// ValuePattern 可能匹配到 distinct_id 及 original_id，这些字段总是以 HashKey 计算 HMAC
+		if (rule.Action == RedactHash || rule.ValuePattern != nil) && len(policy.HashKey) == 0 {
+			return nil, errors.New("redaction hash key must not be empty")
+		}
+	}
+	return &redactor{
+		policy: policy,
+		audit: RedactionAudit{
+			ByRule:   map[string]int64{},
+			ByAction: map[RedactAction]int64{},
+		},
+	}, nil
+}
+
+// apply 处理数据中的 distinct_id、original_id 及 properties。
+// profile_unset 的 properties 是要删除的属性名，值总是 true，不做处理
+func (r *redactor) apply(data map[string]interface{}) {
+	for _, field := range []string{"distinct_id", "original_id"} {
+		if id, ok := data[field].(string); ok && id != "" {
+			data[field] = r.redactID(id, true)
+		}
+	}
+	if data["type"] == "profile_unset" {
+		return
+	}
+	properties, ok := data["properties"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	for key, value := range properties {
+		for _, rule := range r.policy.Rules {
+			if r.matchKey(rule, key) {
+				if redacted, ok := r.redactValue(rule.Action, value); ok {
+					r.record(rule)
+					properties[key] = redacted
+				} else {
+					r.recordAction(rule, RedactDrop)
+					delete(properties, key)
+				}
+				break
+			}
+			if rule.ValuePattern != nil {
+				if redacted, ok := r.redactMatches(rule, value); ok {
+					if redacted == nil {
+						delete(properties, key)
+						break
+					}
+					value = redacted
+					properties[key] = value
+				}
+			}
+		}
+	}
+}
+
+// redactID 处理 distinct_id 等用户标识，匹配时总是替换为 HMAC，record 为 false 时不计入统计
+func (r *redactor) redactID(id string, record bool) string {
+	for _, rule := range r.policy.Rules {
+		if rule.ValuePattern == nil || !rule.ValuePattern.MatchString(id) {
+			continue
+		}
+		if record {
+			r.recordAction(rule, RedactHash)
+		}
+		return r.hash(id)
+	}
+	return id
+}
+
+func (r *redactor) matchKey(rule RedactionRule, key string) bool {
+	for _, k := range rule.Keys {
+		if k == key {
+			return true
+		}
+	}
+	return rule.KeyPattern != nil && rule.KeyPattern.MatchString(key)
+}
+
+// redactValue 处理按属性名匹配到的整个属性值，返回 false 表示删除该属性：
+// RedactDrop，或属性值不是字符串及字符串列表
+func (r *redactor) redactValue(action RedactAction, value interface{}) (interface{}, bool) {
+	if action == RedactDrop {
+		return nil, false
+	}
+	switch v := value.(type) {
+	case string:
+		return r.redactString(action, v), true
+	case []string:
+		redacted := make([]string, len(v))
+		for i, s := range v {
+			redacted[i] = r.redactString(action, s)
+		}
+		return redacted, true
+	}
+	return nil, false
+}
+
+// redactMatches 处理属性值中被 ValuePattern 匹配到的部分，返回 nil 表示删除该属性
+func (r *redactor) redactMatches(rule RedactionRule, value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		if !rule.ValuePattern.MatchString(v) {
+			return nil, false
+		}
+		r.record(rule)
+		if rule.Action == RedactDrop {
+			return nil, true
+		}
+		return r.replaceMatches(rule, v), true
+	case []string:
+		matched := false
+		redacted := make([]string, 0, len(v))
+		for _, s := range v {
+			if !rule.ValuePattern.MatchString(s) {
+				redacted = append(redacted, s)
+				continue
+			}
+			matched = true
+			if rule.Action != RedactDrop {
+				redacted = append(redacted, r.replaceMatches(rule, s))
+			}
+		}
+		if !matched {
+			return nil, false
+		}
+		r.record(rule)
+		return redacted, true
+	}
+	return nil, false
+}
+
+func (r *redactor) replaceMatches(rule RedactionRule, s string) string {
+	return rule.ValuePattern.ReplaceAllStringFunc(s, func(match string) string {
+		return r.redactString(rule.Action, match)
+	})
+}
+
+func (r *redactor) redactString(action RedactAction, s string) string {
+	if action == RedactHash {
+		return r.hash(s)
+	}
+	return mask(s)
+}
+
+func (r *redactor) hash(s string) string {
+	mac := hmac.New(sha256.New, r.policy.HashKey)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// mask 只保留最后 4 个字符
+func mask(s string) string {
+	runes := []rune(s)
+	keep := 4
+	if len(runes) <= keep {
+		return strings.Repeat("*", len(runes))
+	}
+	return strings.Repeat("*", len(runes)-keep) + string(runes[len(runes)-keep:])
+}
+
+func (r *redactor) record(rule RedactionRule) {
+	r.recordAction(rule, rule.Action)
+}
+
+func (r *redactor) recordAction(rule RedactionRule, action RedactAction) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.audit.Total++
+	if rule.Name != "" {
+		r.audit.ByRule[rule.Name]++
+	}
+	r.audit.ByAction[action]++
+}
+
+func (r *redactor) snapshot() RedactionAudit {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	audit := RedactionAudit{
+		Total:    r.audit.Total,
+		ByRule:   make(map[string]int64, len(r.audit.ByRule)),
+		ByAction: make(map[RedactAction]int64, len(r.audit.ByAction)),
+	}
+	for k, v := range r.audit.ByRule {
+		audit.ByRule[k] = v
+	}
+	for k, v := range r.audit.ByAction {
+		audit.ByAction[k] = v
+	}
+	return audit
+}
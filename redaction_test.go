@@ -0,0 +1,157 @@
+package sensorsanalytics_test
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/satest"
+)
+
+func TestPIIPatterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    string
+	}{
+		{"phone", "13812345678", "13812345678"},
+		{"phone", "+8613812345678", "+8613812345678"},
+		{"phone", "8613812345678", "8613812345678"},
+		{"phone", "tel:13812345678.", "13812345678"},
+		{"phone", "手机13812345678", "13812345678"},
+		{"phone", "order 2024138123456789", ""},
+		{"phone", "1713812345678123", ""},
+		{"phone", "138123456789", ""},
+		{"id_card", "11010119900307123X", "11010119900307123X"},
+		{"id_card", "id=110101199003071234;", "110101199003071234"},
+		{"id_card", "91101011990030712345", ""},
+		{"email", "mail: a.b@example.com", "a.b@example.com"},
+	}
+	patterns := map[string]interface{ FindString(string) string }{
+		"phone":   sa.PhonePattern,
+		"id_card": sa.IDCardPattern,
+		"email":   sa.EmailPattern,
+	}
+	for _, tt := range tests {
+		if got := patterns[tt.pattern].FindString(tt.value); got != tt.want {
+			t.Errorf("%s.FindString(%q) = %q, want %q", tt.pattern, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRedactionPolicyRequiresHashKey(t *testing.T) {
+	for _, action := range []sa.RedactAction{sa.RedactDrop, sa.RedactMask, sa.RedactHash} {
+		clt, _ := sa.NewClient(satest.NewRecordingConsumer(), "default", false)
+		if err := clt.SetRedactionPolicy(sa.RedactionPolicy{Rules: sa.PIIRules(action)}); err == nil {
+			t.Errorf("PIIRules(%s) without HashKey: want error", action)
+		}
+	}
+	clt, _ := sa.NewClient(satest.NewRecordingConsumer(), "default", false)
+	err := clt.SetRedactionPolicy(sa.RedactionPolicy{
+		Rules: []sa.RedactionRule{{Keys: []string{"password"}, Action: sa.RedactDrop}},
+	})
+	if err != nil {
+		t.Errorf("key-only drop rule without HashKey: %s", err)
+	}
+}
+
+func TestRedactDistinctIDAlwaysHashed(t *testing.T) {
+	for _, action := range []sa.RedactAction{sa.RedactDrop, sa.RedactMask, sa.RedactHash} {
+		rec := satest.NewRecordingConsumer()
+		clt, _ := sa.NewClient(rec, "default", false)
+		if err := clt.SetRedactionPolicy(sa.RedactionPolicy{Rules: sa.PIIRules(action), HashKey: []byte("k")}); err != nil {
+			t.Fatal(err)
+		}
+		clt.Track("13812345678", "Login", map[string]interface{}{"phone": "13812345678", "order": "2024138123456789"}, false)
+		clt.Track("13912345678", "Login", nil, false)
+		envs := rec.Envelopes()
+		if len(envs) != 2 {
+			t.Fatalf("%s: got %d messages", action, len(envs))
+		}
+		first, second := envs[0].DistinctID, envs[1].DistinctID
+		if len(first) != 64 || strings.Contains(first, "5678") || first == second {
+			t.Errorf("%s: distinct_id %q and %q must be distinct keyed hashes", action, first, second)
+		}
+		if envs[0].Properties["order"] != "2024138123456789" {
+			t.Errorf("%s: order number was redacted: %v", action, envs[0].Properties["order"])
+		}
+		if audit := clt.RedactionAudit(); audit.ByAction[sa.RedactHash] < 2 {
+			t.Errorf("%s: audit %+v must count distinct_id as hashed", action, audit)
+		}
+	}
+}
+
+func TestRedactByKey(t *testing.T) {
+	rec := satest.NewRecordingConsumer()
+	clt, _ := sa.NewClient(rec, "default", false)
+	err := clt.SetRedactionPolicy(sa.RedactionPolicy{
+		Rules: []sa.RedactionRule{
+			{Name: "phone", Keys: []string{"phone", "mobile", "contacts"}, Action: sa.RedactMask},
+			{Name: "secret", Keys: []string{"secret", "pin"}, Action: sa.RedactHash},
+			{Name: "password", KeyPattern: regexp.MustCompile(`^pwd`), Action: sa.RedactDrop},
+		},
+		HashKey: []byte("k"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clt.Track("u1", "Signup", map[string]interface{}{
+		"phone":    "13812345678",
+		"mobile":   int64(13812345678),
+		"contacts": []string{"13812345678", "021"},
+		"secret":   "s3cret",
+		"pin":      1234,
+		"pwd_hash": "abc",
+		"plan":     "pro",
+	}, false)
+
+	props := rec.Envelopes()[0].Properties
+	if props["phone"] != "*******5678" {
+		t.Errorf("phone = %v, want masked", props["phone"])
+	}
+	if contacts, _ := props["contacts"].([]interface{}); len(contacts) != 2 || contacts[0] != "*******5678" || contacts[1] != "***" {
+		t.Errorf("contacts = %v, want each value masked", props["contacts"])
+	}
+	if secret, _ := props["secret"].(string); len(secret) != 64 {
+		t.Errorf("secret = %v, want an HMAC", props["secret"])
+	}
+	// 非字符串的值无法掩码或哈希，直接删除
+	for _, key := range []string{"mobile", "pin", "pwd_hash"} {
+		if v, ok := props[key]; ok {
+			t.Errorf("%s = %v, want it dropped", key, v)
+		}
+	}
+	if props["plan"] != "pro" {
+		t.Errorf("plan = %v, want it unchanged", props["plan"])
+	}
+	audit := clt.RedactionAudit()
+	if audit.ByAction[sa.RedactMask] != 2 || audit.ByAction[sa.RedactHash] != 1 || audit.ByAction[sa.RedactDrop] != 3 {
+		t.Errorf("audit %+v, want 2 masked, 1 hashed and 3 dropped", audit.ByAction)
+	}
+}
+
+func TestRedactSkipsProfileUnset(t *testing.T) {
+	rec := satest.NewRecordingConsumer()
+	clt, _ := sa.NewClient(rec, "default", false)
+	err := clt.SetRedactionPolicy(sa.RedactionPolicy{
+		Rules: append(sa.PIIRules(sa.RedactMask),
+			sa.RedactionRule{Keys: []string{"phone"}, Action: sa.RedactMask},
+			sa.RedactionRule{Keys: []string{"pwd"}, Action: sa.RedactDrop},
+		),
+		HashKey: []byte("k"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := clt.ProfileUnset("13812345678", []string{"phone", "pwd"}, false); err != nil {
+		t.Fatal(err)
+	}
+	e := rec.OfType("profile_unset")[0]
+	if len(e.Properties) != 2 || e.Properties["phone"] != true || e.Properties["pwd"] != true {
+		t.Errorf("profile_unset properties %v, want phone and pwd kept", e.Properties)
+	}
+	if len(e.DistinctID) != 64 {
+		t.Errorf("distinct_id %q, want it hashed", e.DistinctID)
+	}
+}
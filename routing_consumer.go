@@ -0,0 +1,173 @@
+package sensorsanalytics
+
+import (
+	"errors"
+	"path"
+	"reflect"
+)
+
+// RouteMatcher 判断一条数据是否匹配路由规则
+type RouteMatcher func(msg map[string]interface{}) bool
+
+// Route 路由规则，匹配的数据发送给 Consumer
+type Route struct {
+	// Name 规则名称，用于测试及排查问题
+	Name     string
+	Match    RouteMatcher
+	Consumer Consumer
+}
+
+// MatchType 按数据类型匹配，支持 path.Match 通配符，例如 "profile_*"
+func MatchType(patterns ...string) RouteMatcher {
+	return matchField("type", patterns)
+}
+
+// MatchEvent 按事件名称匹配，支持 path.Match 通配符，例如 "Order*"
+func MatchEvent(patterns ...string) RouteMatcher {
+	return matchField("event", patterns)
+}
+
+// MatchProject 按项目名称匹配，支持 path.Match 通配符
+func MatchProject(patterns ...string) RouteMatcher {
+	return matchField("project", patterns)
+}
+
+// MatchAll 所有条件都匹配时匹配
+func MatchAll(matchers ...RouteMatcher) RouteMatcher {
+	return func(msg map[string]interface{}) bool {
+		for _, m := range matchers {
+			if !m(msg) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// MatchAny 任一条件匹配时匹配
+func MatchAny(matchers ...RouteMatcher) RouteMatcher {
+	return func(msg map[string]interface{}) bool {
+		for _, m := range matchers {
+			if m(msg) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func matchField(field string, patterns []string) RouteMatcher {
+	return func(msg map[string]interface{}) bool {
+		value, ok := msg[field].(string)
+		if !ok {
+			return false
+		}
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, value); matched {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// RoutingConsumer 按规则将数据发送给不同的 Consumer，使用第一个匹配的规则，
+// 没有规则匹配时发送给 fallback。
+type RoutingConsumer struct {
+	routes   []Route
+	fallback Consumer
+}
+
+// NewRoutingConsumer 创建新的 RoutingConsumer
+// :param fallback: 没有规则匹配时使用的 Consumer，为 nil 时返回 ErrNoRoute
+// :param routes: 路由规则，按顺序匹配
+func NewRoutingConsumer(fallback Consumer, routes ...Route) (*RoutingConsumer, error) {
+	var c RoutingConsumer
+	for _, route := range routes {
+		if route.Match == nil || route.Consumer == nil {
+			return &c, errors.New("route match and consumer must not be nil")
+		}
+	}
+	c.routes = routes
+	c.fallback = fallback
+	return &c, nil
+}
+
+// Resolve 返回数据匹配的规则名称及 Consumer，使用 fallback 时名称为空
+func (c *RoutingConsumer) Resolve(msg map[string]interface{}) (string, Consumer) {
+	for _, route := range c.routes {
+		if route.Match(msg) {
+			return route.Name, route.Consumer
+		}
+	}
+	return "", c.fallback
+}
+
+// Send 发送数据
+func (c *RoutingConsumer) Send(msg map[string]interface{}) error {
+	_, consumer := c.Resolve(msg)
+	if consumer == nil {
+		return ErrNoRoute
+	}
+	return consumer.Send(msg)
+}
+
+// Flush 对所有 Consumer 调用 Flush
+func (c *RoutingConsumer) Flush() error {
+	return c.each(func(consumer Consumer) error {
+		return consumer.Flush()
+	})
+}
+
+// Close 对所有 Consumer 调用 Close
+func (c *RoutingConsumer) Close() error {
+	return c.each(func(consumer Consumer) error {
+		return consumer.Close()
+	})
+}
+
+// Purge 删除所有 Consumer 中指定用户尚未发送的数据
+func (c *RoutingConsumer) Purge(distinctID string) int {
+	purged := 0
+	for _, consumer := range c.consumers() {
+		purged += purge(consumer, distinctID)
+	}
+	return purged
+}
+
+// each 对每个 Consumer 执行一次 fn，多个规则使用同一个 Consumer 时只执行一次
+func (c *RoutingConsumer) each(fn func(consumer Consumer) error) error {
+	var errs []ConsumerError
+	for i, consumer := range c.consumers() {
+		if err := fn(consumer); err != nil {
+			errs = append(errs, ConsumerError{Index: i, Err: err})
+		}
+	}
+	if len(errs) > 0 {
+		return &MultiError{Errors: errs}
+	}
+	return nil
+}
+
+// consumers 返回去重后的所有 Consumer，fallback 在最后
+func (c *RoutingConsumer) consumers() []Consumer {
+	consumers := make([]Consumer, 0, len(c.routes)+1)
+	for _, route := range c.routes {
+		consumers = appendUnique(consumers, route.Consumer)
+	}
+	if c.fallback != nil {
+		consumers = appendUnique(consumers, c.fallback)
+	}
+	return consumers
+}
+
+func appendUnique(consumers []Consumer, consumer Consumer) []Consumer {
+	if reflect.TypeOf(consumer).Comparable() {
+		for _, existing := range consumers {
+			if existing == consumer {
+				return consumers
+			}
+		}
+	}
+	return append(consumers, consumer)
+}
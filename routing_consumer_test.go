@@ -0,0 +1,91 @@
+package sensorsanalytics_test
+
+import (
+	"errors"
+	"testing"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+func TestRoutingConsumerRules(t *testing.T) {
+	profiles := &recordingConsumer{}
+	orders := &recordingConsumer{}
+	tracks := &recordingConsumer{}
+	fallback := &recordingConsumer{}
+	routing, err := sa.NewRoutingConsumer(fallback,
+		sa.Route{Name: "profiles", Match: sa.MatchType("profile_*"), Consumer: profiles},
+		sa.Route{Name: "orders", Match: sa.MatchAll(sa.MatchType("track"), sa.MatchEvent("Order*")), Consumer: orders},
+		sa.Route{Name: "tracks", Match: sa.MatchAny(sa.MatchType("track", "track_signup"), sa.MatchProject("staging")), Consumer: tracks},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := func(fields ...string) map[string]interface{} {
+		m := map[string]interface{}{}
+		for i := 0; i+1 < len(fields); i += 2 {
+			m[fields[i]] = fields[i+1]
+		}
+		return m
+	}
+	tests := []struct {
+		name     string
+		msg      map[string]interface{}
+		wantName string
+		want     sa.Consumer
+	}{
+		{"profile_set", msg("type", "profile_set"), "profiles", profiles},
+		{"profile_delete", msg("type", "profile_delete"), "profiles", profiles},
+		{"order event", msg("type", "track", "event", "OrderPaid"), "orders", orders},
+		{"first matching rule wins", msg("type", "track", "event", "OrderPaid", "project", "staging"), "orders", orders},
+		{"other event", msg("type", "track", "event", "PageView"), "tracks", tracks},
+		{"signup", msg("type", "track_signup", "event", "$SignUp"), "tracks", tracks},
+		{"project", msg("type", "item_set", "project", "staging"), "tracks", tracks},
+		{"no rule", msg("type", "item_set"), "", fallback},
+		{"field is not a string", map[string]interface{}{"type": 1}, "", fallback},
+	}
+	for _, tt := range tests {
+		name, consumer := routing.Resolve(tt.msg)
+		if name != tt.wantName || consumer != tt.want {
+			t.Errorf("%s: Resolve() = %q, want %q", tt.name, name, tt.wantName)
+		}
+	}
+}
+
+func TestRoutingConsumer(t *testing.T) {
+	profiles := &recordingConsumer{}
+	tracks := &recordingConsumer{}
+	routing, _ := sa.NewRoutingConsumer(nil,
+		sa.Route{Name: "profiles", Match: sa.MatchType("profile_*"), Consumer: profiles},
+		sa.Route{Name: "signups", Match: sa.MatchType("track_signup"), Consumer: tracks},
+		sa.Route{Name: "tracks", Match: sa.MatchType("track"), Consumer: tracks},
+	)
+	clt, _ := sa.NewClient(routing, "default", false)
+	clt.Track("u1", "OrderPaid", nil, false)
+	clt.ProfileSet("u1", map[string]interface{}{"VIP": true}, false)
+	if len(tracks.ofType("track")) != 1 || len(profiles.ofType("profile_set")) != 1 || len(profiles.ofType("track")) != 0 {
+		t.Errorf("tracks got %v, profiles got %v", tracks.msgs, profiles.msgs)
+	}
+
+	if err := routing.Send(map[string]interface{}{"type": "item_set"}); !errors.Is(err, sa.ErrNoRoute) {
+		t.Errorf("Send without matching rule: %v, want ErrNoRoute", err)
+	}
+	// 多个规则使用同一个 Consumer 时只调用一次
+	if err := routing.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if profiles.closes != 1 || tracks.closes != 1 {
+		t.Errorf("Close calls: profiles %d, tracks %d", profiles.closes, tracks.closes)
+	}
+	if n := routing.Purge("u1"); n != 2 {
+		t.Errorf("Purge(u1) = %d, want 2", n)
+	}
+
+	tracks.err = errors.New("down")
+	var multiErr *sa.MultiError
+	if err := routing.Send(map[string]interface{}{"type": "track"}); err == nil || errors.As(err, &multiErr) {
+		t.Errorf("Send to failing route: %v, want the consumer's own error", err)
+	}
+	if _, err := sa.NewRoutingConsumer(nil, sa.Route{Name: "empty", Match: sa.MatchType("track")}); err == nil {
+		t.Error("NewRoutingConsumer with nil route consumer succeeded")
+	}
+}
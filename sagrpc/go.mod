@@ -0,0 +1,18 @@
+module gopkg.in/CuriosityChina/sa-sdk-go.v1/sagrpc
+
+// google.golang.org/grpc v1.82 requires go 1.25. sagrpc is a separate module
+// so that the root module keeps supporting go 1.18.
+go 1.25.0
+
+require (
+	google.golang.org/grpc v1.82.1
+	gopkg.in/CuriosityChina/sa-sdk-go.v1 v1.7.2-0.20261018125057-fc82d66408f8
+)
+
+require (
+	golang.org/x/net v0.53.0 // indirect
+	golang.org/x/sys v0.43.0 // indirect
+	golang.org/x/text v0.36.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20260414002931-afd174a4e478 // indirect
+	google.golang.org/protobuf v1.36.11 // indirect
+)
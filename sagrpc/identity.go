@@ -0,0 +1,56 @@
+// Package sagrpc 提供 gRPC 拦截器：服务端从请求的 metadata 中识别用户并将绑定了该用户的 Client 放入 context，
+// 处理函数可以直接调用 sa.TrackContext(ctx, ...) 发送事件；客户端将 context 中的用户标识写入 metadata 传给下游服务，
+// 整个调用链上的数据属于同一用户。
+package sagrpc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+const (
+	// MetadataDistinctID 传递用户标识的 metadata 键
+	MetadataDistinctID = "sa-distinct-id"
+	// MetadataIsLoginID 传递用户标识是否为登录 ID 的 metadata 键，值为 "true" 或 "false"
+	MetadataIsLoginID = "sa-is-login-id"
+)
+
+// IdentityFunc 从请求的 context 中识别用户，无法识别时返回 false，多个 IdentityFunc 可以用 sa.FirstOf 组合
+type IdentityFunc = sa.IdentityFunc[context.Context]
+
+// FromMetadata 从请求的 metadata 中读取用户标识
+// :param key: 用户标识的 metadata 键
+// :param isLoginID: 该键中的标识是否为登录 ID
+func FromMetadata(key string, isLoginID bool) IdentityFunc {
+	return func(ctx context.Context) (sa.Identity, bool) {
+		id := firstValue(ctx, key)
+		if id == "" {
+			return sa.Identity{}, false
+		}
+		return sa.Identity{DistinctID: id, IsLoginID: isLoginID}, true
+	}
+}
+
+// FromPropagated 读取上游服务的客户端拦截器写入的 sa-distinct-id 及 sa-is-login-id
+func FromPropagated() IdentityFunc {
+	return func(ctx context.Context) (sa.Identity, bool) {
+		id := firstValue(ctx, MetadataDistinctID)
+		if id == "" {
+			return sa.Identity{}, false
+		}
+		isLoginID, _ := strconv.ParseBool(firstValue(ctx, MetadataIsLoginID))
+		return sa.Identity{DistinctID: id, IsLoginID: isLoginID}, true
+	}
+}
+
+func firstValue(ctx context.Context, key string) string {
+	values := metadata.ValueFromIncomingContext(ctx, key)
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(values[0])
+}
@@ -0,0 +1,126 @@
+package sagrpc
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+// Config 服务端拦截器的配置
+type Config struct {
+	// Identity 识别用户的方式，默认为 FromPropagated()。
+	// 无法识别用户的请求不放入 BoundClient，也不发送请求事件
+	Identity IdentityFunc
+	// RequestEvent 每个请求结束后发送的事件名称，例如 "RpcHandled"，为空时不发送。
+	// 事件属性为 rpc_method、rpc_code 及 duration_ms
+	RequestEvent string
+	// Properties 返回请求事件的其他属性
+	Properties func(ctx context.Context, fullMethod string) map[string]interface{}
+	// Skip 返回 true 的方法不发送请求事件，例如健康检查
+	Skip func(fullMethod string) bool
+	// OnError 发送请求事件失败时调用，默认忽略
+	OnError func(fullMethod string, err error)
+}
+
+// bind 识别用户并返回带有 BoundClient 的 context，无法识别时返回 nil
+func bind(ctx context.Context, client *sa.Client, config Config) (context.Context, *sa.BoundClient) {
+	identity := config.Identity
+	if identity == nil {
+		identity = FromPropagated()
+	}
+	id, ok := identity(ctx)
+	if !ok {
+		return ctx, nil
+	}
+	bound := client.BindIdentity(id)
+	return sa.NewContext(ctx, bound), bound
+}
+
+// track 按配置发送请求事件
+func track(ctx context.Context, config Config, bound *sa.BoundClient, fullMethod string, start time.Time, err error) {
+	if bound == nil || config.RequestEvent == "" || (config.Skip != nil && config.Skip(fullMethod)) {
+		return
+	}
+	properties := map[string]interface{}{}
+	if config.Properties != nil {
+		for k, v := range config.Properties(ctx, fullMethod) {
+			properties[k] = v
+		}
+	}
+	properties["rpc_method"] = fullMethod
+	properties["rpc_code"] = status.Code(err).String()
+	properties["duration_ms"] = time.Since(start).Milliseconds()
+	if err := bound.Track(config.RequestEvent, properties); err != nil && config.OnError != nil {
+		config.OnError(fullMethod, err)
+	}
+}
+
+// UnaryServerInterceptor 返回服务端一元拦截器，识别用户后将 BoundClient 放入 context，
+// 并按配置在每个请求结束后发送请求事件
+func UnaryServerInterceptor(client *sa.Client, config Config) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		ctx, bound := bind(ctx, client, config)
+		resp, err := handler(ctx, req)
+		track(ctx, config, bound, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor 返回服务端流拦截器，识别用户后将 BoundClient 放入流的 context，
+// 并按配置在流结束后发送请求事件
+func StreamServerInterceptor(client *sa.Client, config Config) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx, bound := bind(ss.Context(), client, config)
+		if bound != nil {
+			ss = &serverStream{ServerStream: ss, ctx: ctx}
+		}
+		err := handler(srv, ss)
+		track(ctx, config, bound, info.FullMethod, start, err)
+		return err
+	}
+}
+
+// serverStream 替换 context 的 ServerStream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// UnaryClientInterceptor 返回客户端一元拦截器，将 context 中绑定的用户标识写入 metadata 传给下游服务
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(propagate(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor 返回客户端流拦截器，将 context 中绑定的用户标识写入 metadata 传给下游服务
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(propagate(ctx), desc, cc, method, opts...)
+	}
+}
+
+// propagate 将 context 中 BoundClient 的用户标识写入发出请求的 metadata，调用方已设置时不覆盖
+func propagate(ctx context.Context) context.Context {
+	bound, ok := sa.FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(MetadataDistinctID)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx,
+		MetadataDistinctID, bound.DistinctID(),
+		MetadataIsLoginID, strconv.FormatBool(bound.IsLoginID()))
+}
@@ -0,0 +1,67 @@
+package sagrpc_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/sagrpc"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/satest"
+)
+
+func TestInterceptors(t *testing.T) {
+	rec := satest.NewRecordingConsumer()
+	clt, _ := sa.NewClient(rec, "default", false)
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(sagrpc.UnaryServerInterceptor(clt, sagrpc.Config{RequestEvent: "RpcHandled"})),
+		grpc.StreamInterceptor(sagrpc.StreamServerInterceptor(clt, sagrpc.Config{RequestEvent: "RpcHandled"})),
+	)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///buf",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(sagrpc.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(sagrpc.StreamClientInterceptor()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	hc := healthpb.NewHealthClient(conn)
+	ctx := sa.NewContext(context.Background(), clt.Bind("u1", true))
+	if _, err := hc.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	hc.Check(ctx, &healthpb.HealthCheckRequest{Service: "missing"})
+	hc.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	sctx, cancel := context.WithCancel(ctx)
+	st, err := hc.Watch(sctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.Recv()
+	cancel()
+	srv.GracefulStop()
+	evs := rec.Tracked("RpcHandled")
+	if len(evs) != 3 {
+		t.Fatalf("%d %+v", len(evs), evs)
+	}
+	for _, e := range evs {
+		if e.DistinctID != "u1" || e.Properties["$is_login_id"] != true {
+			t.Fatal(e)
+		}
+	}
+	if evs[1].Properties["rpc_code"] != "NotFound" || evs[0].Properties["rpc_code"] != "OK" {
+		t.Fatal(evs)
+	}
+}
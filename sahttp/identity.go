@@ -0,0 +1,42 @@
+// Package sahttp 提供 net/http 中间件，从请求中识别用户并将绑定了该用户的 Client 放入 context，
+// 处理函数可以直接调用 sahttp.Track(ctx, ...) 或 sa.TrackContext(ctx, ...) 发送事件。
+package sahttp
+
+import (
+	"net/http"
+	"strings"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+// IdentityFunc 从请求中识别用户，无法识别时返回 false，多个 IdentityFunc 可以用 FirstOf 组合
+type IdentityFunc = sa.IdentityFunc[*http.Request]
+
+// FirstOf 按顺序尝试多个 IdentityFunc，返回第一个识别出的用户，与 sa.FirstOf 相同
+func FirstOf(fns ...IdentityFunc) IdentityFunc {
+	return sa.FirstOf(fns...)
+}
+
+// FromHeader 从请求头 name 中读取用户标识
+// :param isLoginID: 该请求头中的标识是否为登录 ID
+func FromHeader(name string, isLoginID bool) IdentityFunc {
+	return func(r *http.Request) (sa.Identity, bool) {
+		id := strings.TrimSpace(r.Header.Get(name))
+		if id == "" {
+			return sa.Identity{}, false
+		}
+		return sa.Identity{DistinctID: id, IsLoginID: isLoginID}, true
+	}
+}
+
+// FromCookie 从名为 name 的 cookie 中读取用户标识
+// :param isLoginID: 该 cookie 中的标识是否为登录 ID
+func FromCookie(name string, isLoginID bool) IdentityFunc {
+	return func(r *http.Request) (sa.Identity, bool) {
+		cookie, err := r.Cookie(name)
+		if err != nil || cookie.Value == "" {
+			return sa.Identity{}, false
+		}
+		return sa.Identity{DistinctID: cookie.Value, IsLoginID: isLoginID}, true
+	}
+}
@@ -0,0 +1,161 @@
+package sahttp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+// Config Middleware 的配置
+type Config struct {
+	// Identity 识别用户的方式，无法识别用户的请求不放入 BoundClient，也不发送请求事件
+	Identity IdentityFunc
+	// RequestEvent 每个请求结束后发送的事件名称，为空时不发送。
+	// 事件属性为 http_method、http_route、http_status 及 latency_ms
+	RequestEvent string
+	// Route 返回请求的路由，用于 http_route 属性，默认为 URL 的路径。
+	// 路径中带有 ID 时建议返回路由模板，例如 "/orders/{id}"
+	Route func(r *http.Request) string
+	// Properties 返回请求事件的其他属性，例如 User-Agent
+	Properties func(r *http.Request) map[string]interface{}
+	// Skip 返回 true 的请求不发送请求事件，例如健康检查
+	Skip func(r *http.Request) bool
+	// OnError 发送请求事件失败时调用，默认忽略
+	OnError func(r *http.Request, err error)
+}
+
+// Middleware 返回 net/http 中间件，识别用户后将 BoundClient 放入请求的 context，
+// 并按配置在每个请求结束后发送请求事件。
+// 无法识别用户的请求照常交给 next 处理，但不发送请求事件，也不记录日志或调用 OnError；
+// 此时 context 中没有 BoundClient，Track 及 sa.TrackContext 返回 sa.ErrNoBoundClient
+func Middleware(client *sa.Client, config Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if config.Identity == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			id, ok := config.Identity(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			bound := client.BindIdentity(id)
+			r = r.WithContext(sa.NewContext(r.Context(), bound))
+			if config.RequestEvent == "" || (config.Skip != nil && config.Skip(r)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if err := bound.Track(config.RequestEvent, requestProperties(config, r, rec.status(), time.Since(start))); err != nil && config.OnError != nil {
+				config.OnError(r, err)
+			}
+		})
+	}
+}
+
+// Client 返回 context 中绑定了用户的 Client
+func Client(ctx context.Context) (*sa.BoundClient, bool) {
+	return sa.FromContext(ctx)
+}
+
+// Track 使用 context 中绑定了用户的 Client 发送事件，没有时返回 sa.ErrNoBoundClient，
+// 与 sa.TrackContext 相同
+func Track(ctx context.Context, eventName string, properties map[string]interface{}) error {
+	return sa.TrackContext(ctx, eventName, properties)
+}
+
+// ProfileSet 使用 context 中绑定了用户的 Client 设置用户属性，没有时返回 sa.ErrNoBoundClient，
+// 与 sa.ProfileSetContext 相同
+func ProfileSet(ctx context.Context, profiles map[string]interface{}) error {
+	return sa.ProfileSetContext(ctx, profiles)
+}
+
+func requestProperties(config Config, r *http.Request, status int, latency time.Duration) map[string]interface{} {
+	route := r.URL.Path
+	if config.Route != nil {
+		route = config.Route(r)
+	}
+	properties := map[string]interface{}{}
+	if config.Properties != nil {
+		for k, v := range config.Properties(r) {
+			properties[k] = v
+		}
+	}
+	properties["http_method"] = r.Method
+	properties["http_route"] = route
+	properties["http_status"] = status
+	properties["latency_ms"] = latency.Milliseconds()
+	return properties
+}
+
+// statusRecorder 记录响应的状态码
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush 支持流式响应
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.code == 0 {
+			w.code = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Hijack 支持 websocket 等协议升级，底层的 ResponseWriter 不支持时返回错误
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("sahttp: %T does not implement http.Hijacker", w.ResponseWriter)
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && w.code == 0 {
+		w.code = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Push 支持 HTTP/2 服务器推送
+func (w *statusRecorder) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap 供 http.ResponseController 使用
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *statusRecorder) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
@@ -0,0 +1,250 @@
+package sahttp_test
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/sahttp"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/satest"
+)
+
+func newServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *satest.RecordingConsumer) {
+	t.Helper()
+	rec := satest.NewRecordingConsumer()
+	client, err := sa.NewClient(rec, "default", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	middleware := sahttp.Middleware(client, sahttp.Config{
+		Identity:     sahttp.FromHeader("X-User-ID", true),
+		RequestEvent: "HttpRequest",
+	})
+	srv := httptest.NewServer(middleware(handler))
+	t.Cleanup(srv.Close)
+	return srv, rec
+}
+
+func TestMiddlewareHijack(t *testing.T) {
+	srv, rec := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "no hijacker", http.StatusInternalServerError)
+			return
+		}
+		conn, rw, err := hijacker.Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+	})
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("X-User-ID", "u1")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d, want 101", resp.StatusCode)
+	}
+	waitTracked(t, rec, "HttpRequest", map[string]interface{}{"http_status": float64(101)})
+}
+
+func TestMiddlewareFlush(t *testing.T) {
+	srv, rec := newServer(t, func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "no flusher", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("first\n"))
+		flusher.Flush()
+		w.Write([]byte("second\n"))
+	})
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("X-User-ID", "u1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "first\n" || resp.StatusCode != http.StatusOK {
+		t.Fatalf("got %d %q %v", resp.StatusCode, line, err)
+	}
+	resp.Body.Close()
+	waitTracked(t, rec, "HttpRequest", map[string]interface{}{"http_status": float64(200)})
+}
+
+// waitTracked 请求事件在处理函数返回后发送，客户端可能先收到响应
+func waitTracked(t *testing.T, rec *satest.RecordingConsumer, eventName string, props map[string]interface{}) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); len(rec.Tracked(eventName)) == 0 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	satest.AssertTracked(t, rec, eventName, props)
+}
+
+func TestTrackUsesBoundClient(t *testing.T) {
+	rec := satest.NewRecordingConsumer()
+	client, err := sa.NewClient(rec, "default", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trackErr error
+	handler := sahttp.Middleware(client, sahttp.Config{
+		Identity: sahttp.FirstOf(sahttp.FromHeader("X-User-ID", true), sahttp.FromCookie("anonymous_id", false)),
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trackErr = sahttp.Track(r.Context(), "OrderPaid", map[string]interface{}{"amount": 10})
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: "anonymous_id", Value: "a1"})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if trackErr != nil {
+		t.Fatal(trackErr)
+	}
+	events := rec.Tracked("OrderPaid")
+	if len(events) != 1 || events[0].DistinctID != "a1" || events[0].Properties["$is_login_id"] == true {
+		t.Fatalf("tracked %+v, want one anonymous event for a1", events)
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if trackErr != sa.ErrNoBoundClient {
+		t.Errorf("Track without identity returned %v, want ErrNoBoundClient", trackErr)
+	}
+}
+
+func TestMiddlewareIdentity(t *testing.T) {
+	rec := satest.NewRecordingConsumer()
+	client, err := sa.NewClient(rec, "default", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bound *sa.BoundClient
+	handler := sahttp.Middleware(client, sahttp.Config{
+		Identity: sahttp.FirstOf(sahttp.FromHeader("X-User-ID", true), sahttp.FromCookie("anonymous_id", false)),
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bound, _ = sahttp.Client(r.Context())
+	}))
+
+	tests := []struct {
+		name      string
+		header    string
+		cookie    string
+		want      string
+		wantLogin bool
+	}{
+		{name: "header", header: " u1 ", cookie: "a1", want: "u1", wantLogin: true},
+		{name: "cookie", cookie: "a1", want: "a1"},
+		{name: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bound = nil
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("X-User-ID", tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "anonymous_id", Value: tt.cookie})
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if tt.want == "" {
+				if bound != nil {
+					t.Errorf("bound %q, want no client", bound.DistinctID())
+				}
+				return
+			}
+			if bound == nil || bound.DistinctID() != tt.want || bound.IsLoginID() != tt.wantLogin {
+				t.Errorf("bound %+v, want %s (login %v)", bound, tt.want, tt.wantLogin)
+			}
+		})
+	}
+	satest.AssertNoEvents(t, rec)
+}
+
+func TestMiddlewareRequestEvent(t *testing.T) {
+	rec := satest.NewRecordingConsumer()
+	client, err := sa.NewClient(rec, "default", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var errs []error
+	handler := sahttp.Middleware(client, sahttp.Config{
+		Identity:     sahttp.FromHeader("X-User-ID", true),
+		RequestEvent: "HttpRequest",
+		Route: func(r *http.Request) string {
+			if strings.HasPrefix(r.URL.Path, "/orders/") {
+				return "/orders/{id}"
+			}
+			return r.URL.Path
+		},
+		Properties: func(r *http.Request) map[string]interface{} {
+			return map[string]interface{}{"user_agent": r.UserAgent(), "http_status": "overridden"}
+		},
+		Skip:    func(r *http.Request) bool { return r.URL.Path == "/healthz" },
+		OnError: func(r *http.Request, err error) { errs = append(errs, err) },
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/orders/42":
+			time.Sleep(20 * time.Millisecond)
+			w.WriteHeader(http.StatusCreated)
+		case "/missing":
+			http.NotFound(w, r)
+		}
+	}))
+	serve := func(method string, path string, user string) {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("User-Agent", "test")
+		if user != "" {
+			req.Header.Set("X-User-ID", user)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve(http.MethodPost, "/orders/42", "u1")
+	serve(http.MethodGet, "/missing", "u1")
+	serve(http.MethodGet, "/empty", "u1")
+	serve(http.MethodGet, "/healthz", "u1")
+	serve(http.MethodGet, "/orders/42", "")
+
+	events := rec.Tracked("HttpRequest")
+	if len(events) != 3 {
+		t.Fatalf("tracked %d request events, want 3 (skipped and anonymous requests excluded)", len(events))
+	}
+	order := events[0]
+	if order.DistinctID != "u1" || order.Properties["$is_login_id"] != true {
+		t.Errorf("event for %s, want the identified user", order.DistinctID)
+	}
+	want := map[string]interface{}{"http_method": "POST", "http_route": "/orders/{id}", "http_status": float64(201), "user_agent": "test"}
+	satest.AssertTracked(t, rec, "HttpRequest", want)
+	if latency, _ := order.Properties["latency_ms"].(float64); latency < 20 {
+		t.Errorf("latency_ms %v, want at least 20", order.Properties["latency_ms"])
+	}
+	if events[1].Properties["http_status"] != float64(404) || events[1].Properties["http_route"] != "/missing" {
+		t.Errorf("not found event %v", events[1].Properties)
+	}
+	// 处理函数没有写入响应时状态码为 200
+	if events[2].Properties["http_status"] != float64(200) {
+		t.Errorf("empty response event %v, want http_status 200", events[2].Properties)
+	}
+
+	rec.SetError(errors.New("consumer down"))
+	serve(http.MethodGet, "/empty", "u1")
+	serve(http.MethodGet, "/empty", "")
+	if len(errs) != 1 || errs[0].Error() != "consumer down" {
+		t.Errorf("OnError got %v, want one error for the identified request", errs)
+	}
+}
@@ -0,0 +1,127 @@
+package sahttp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+// JSSDKCookie Web JS SDK 保存用户标识的 cookie
+const JSSDKCookie = "sensorsdata2015jssdkcross"
+
+// SensorsHeaders 小程序及 App 没有 cookie 时传入用户标识的请求头名称，为空的字段不读取。
+// 神策的协议中没有这样的请求头，SDK 也不会自动设置，需要客户端读取 SDK 的匿名 ID 及登录 ID 后
+// 自行放入请求头，名称由服务端与客户端约定
+type SensorsHeaders struct {
+	// AnonymousID 匿名 ID 的请求头
+	AnonymousID string
+	// LoginID 登录 ID 的请求头
+	LoginID string
+}
+
+// SensorsIdentity 客户端 SDK 记录的用户标识
+type SensorsIdentity struct {
+	// AnonymousID 匿名 ID，登录前的 distinct_id
+	AnonymousID string
+	// LoginID 登录 ID，未登录时为空
+	LoginID string
+	// DeviceID 设备 ID，JS SDK 的 $device_id
+	DeviceID string
+}
+
+// Identity 返回发送数据使用的用户标识，已登录时为登录 ID，否则为匿名 ID
+func (s SensorsIdentity) Identity() (sa.Identity, bool) {
+	if s.LoginID != "" {
+		return sa.Identity{DistinctID: s.LoginID, IsLoginID: true}, true
+	}
+	if s.AnonymousID != "" {
+		return sa.Identity{DistinctID: s.AnonymousID}, true
+	}
+	return sa.Identity{}, false
+}
+
+// jssdkCookie JS SDK cookie 的内容
+type jssdkCookie struct {
+	DistinctID string `json:"distinct_id"`
+	// FirstID 登录后为登录前的匿名 ID，此时 DistinctID 为登录 ID
+	FirstID  string `json:"first_id"`
+	DeviceID string `json:"$device_id"`
+	// Identities 新版 SDK 的 base64 编码的 ID 集合
+	Identities string `json:"identities"`
+}
+
+// ParseJSSDKCookie 解析 sensorsdata2015jssdkcross cookie 的值 (URL 编码的 JSON)
+func ParseJSSDKCookie(value string) (SensorsIdentity, error) {
+	var s SensorsIdentity
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		value = unescaped
+	}
+	var cookie jssdkCookie
+	if err := json.Unmarshal([]byte(value), &cookie); err != nil {
+		return s, err
+	}
+	s.DeviceID = cookie.DeviceID
+	if cookie.FirstID != "" {
+		s.AnonymousID = cookie.FirstID
+		s.LoginID = cookie.DistinctID
+	} else {
+		s.AnonymousID = cookie.DistinctID
+	}
+	if cookie.Identities != "" {
+		if b, err := base64.StdEncoding.DecodeString(cookie.Identities); err == nil {
+			var identities map[string]string
+			if json.Unmarshal(b, &identities) == nil {
+				if id := identities["$identity_login_id"]; id != "" {
+					s.LoginID = id
+				}
+				if id := identities["$identity_anonymous_id"]; id != "" && s.AnonymousID == "" {
+					s.AnonymousID = id
+				}
+				if id := identities["$identity_cookie_id"]; id != "" && s.AnonymousID == "" {
+					s.AnonymousID = id
+				}
+			}
+		}
+	}
+	if s.AnonymousID == "" && s.LoginID == "" {
+		return s, errors.New("cookie contains no distinct_id")
+	}
+	return s, nil
+}
+
+// ParseSensorsIdentity 从请求中读取客户端 SDK 记录的用户标识：
+// 优先使用 JS SDK 的 cookie，其次使用 headers 中的请求头
+// :param headers: 客户端传入用户标识的请求头，零值时只读取 cookie
+func ParseSensorsIdentity(r *http.Request, headers SensorsHeaders) (SensorsIdentity, bool) {
+	if cookie, err := r.Cookie(JSSDKCookie); err == nil {
+		if s, err := ParseJSSDKCookie(cookie.Value); err == nil {
+			return s, true
+		}
+	}
+	var s SensorsIdentity
+	if headers.AnonymousID != "" {
+		s.AnonymousID = strings.TrimSpace(r.Header.Get(headers.AnonymousID))
+	}
+	if headers.LoginID != "" {
+		s.LoginID = strings.TrimSpace(r.Header.Get(headers.LoginID))
+	}
+	return s, s.AnonymousID != "" || s.LoginID != ""
+}
+
+// FromSensorsSDK 使用客户端 SDK 记录的用户标识，已登录时为登录 ID，否则为匿名 ID，
+// 服务端发送的数据与客户端的数据属于同一用户
+// :param headers: 客户端传入用户标识的请求头，零值时只读取 JS SDK 的 cookie
+func FromSensorsSDK(headers SensorsHeaders) IdentityFunc {
+	return func(r *http.Request) (sa.Identity, bool) {
+		s, ok := ParseSensorsIdentity(r, headers)
+		if !ok {
+			return sa.Identity{}, false
+		}
+		return s.Identity()
+	}
+}
@@ -0,0 +1,149 @@
+package sahttp_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/sahttp"
+)
+
+func identities(ids string) string {
+	return base64.StdEncoding.EncodeToString([]byte(ids))
+}
+
+func TestParseJSSDKCookie(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    sahttp.SensorsIdentity
+		wantErr bool
+	}{
+		{
+			name:  "anonymous",
+			value: url.PathEscape(`{"distinct_id":"18a1b2c3","first_id":"","props":{"$latest_referrer":""},"$device_id":"18a1b2c3"}`),
+			want:  sahttp.SensorsIdentity{AnonymousID: "18a1b2c3", DeviceID: "18a1b2c3"},
+		},
+		{
+			name:  "login",
+			value: url.PathEscape(`{"distinct_id":"user-1","first_id":"18a1b2c3","$device_id":"18a1b2c3"}`),
+			want:  sahttp.SensorsIdentity{AnonymousID: "18a1b2c3", LoginID: "user-1", DeviceID: "18a1b2c3"},
+		},
+		{
+			name:  "unescaped",
+			value: `{"distinct_id":"18a1b2c3"}`,
+			want:  sahttp.SensorsIdentity{AnonymousID: "18a1b2c3"},
+		},
+		{
+			name:  "identities login",
+			value: url.PathEscape(`{"distinct_id":"18a1b2c3","identities":"` + identities(`{"$identity_cookie_id":"18a1b2c3","$identity_login_id":"user-1"}`) + `"}`),
+			want:  sahttp.SensorsIdentity{AnonymousID: "18a1b2c3", LoginID: "user-1"},
+		},
+		{
+			name:  "identities only",
+			value: url.PathEscape(`{"identities":"` + identities(`{"$identity_anonymous_id":"anon-1"}`) + `"}`),
+			want:  sahttp.SensorsIdentity{AnonymousID: "anon-1"},
+		},
+		{
+			name:  "invalid identities ignored",
+			value: url.PathEscape(`{"distinct_id":"18a1b2c3","identities":"%%%"}`),
+			want:  sahttp.SensorsIdentity{AnonymousID: "18a1b2c3"},
+		},
+		{name: "malformed", value: "%7B%22distinct_id%22", wantErr: true},
+		{name: "not json", value: "abc", wantErr: true},
+		{name: "missing distinct_id", value: url.PathEscape(`{"$device_id":"18a1b2c3"}`), wantErr: true},
+		{name: "empty", value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sahttp.ParseJSSDKCookie(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseJSSDKCookie() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseJSSDKCookie() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSensorsIdentity(t *testing.T) {
+	tests := []struct {
+		ids    sahttp.SensorsIdentity
+		want   sa.Identity
+		wantOK bool
+	}{
+		{sahttp.SensorsIdentity{AnonymousID: "a1", LoginID: "u1"}, sa.Identity{DistinctID: "u1", IsLoginID: true}, true},
+		{sahttp.SensorsIdentity{AnonymousID: "a1"}, sa.Identity{DistinctID: "a1"}, true},
+		{sahttp.SensorsIdentity{DeviceID: "d1"}, sa.Identity{}, false},
+	}
+	for _, tt := range tests {
+		if got, ok := tt.ids.Identity(); got != tt.want || ok != tt.wantOK {
+			t.Errorf("%+v.Identity() = %+v, %v, want %+v, %v", tt.ids, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestFromSensorsSDK(t *testing.T) {
+	headers := sahttp.SensorsHeaders{AnonymousID: "X-Anonymous-Id", LoginID: "X-Login-Id"}
+	loginCookie := url.PathEscape(`{"distinct_id":"user-1","first_id":"18a1b2c3"}`)
+	tests := []struct {
+		name    string
+		cookie  string
+		header  map[string]string
+		headers sahttp.SensorsHeaders
+		want    sa.Identity
+		wantOK  bool
+	}{
+		{name: "login cookie", cookie: loginCookie, headers: headers, want: sa.Identity{DistinctID: "user-1", IsLoginID: true}, wantOK: true},
+		{
+			name:    "cookie before headers",
+			cookie:  url.PathEscape(`{"distinct_id":"18a1b2c3"}`),
+			header:  map[string]string{"X-Login-Id": "user-2"},
+			headers: headers,
+			want:    sa.Identity{DistinctID: "18a1b2c3"},
+			wantOK:  true,
+		},
+		{name: "anonymous header", header: map[string]string{"X-Anonymous-Id": " anon-1 "}, headers: headers, want: sa.Identity{DistinctID: "anon-1"}, wantOK: true},
+		{
+			name:    "malformed cookie falls back to headers",
+			cookie:  "not-json",
+			header:  map[string]string{"X-Anonymous-Id": "anon-1", "X-Login-Id": "user-2"},
+			headers: headers,
+			want:    sa.Identity{DistinctID: "user-2", IsLoginID: true},
+			wantOK:  true,
+		},
+		{name: "headers not configured", header: map[string]string{"X-Login-Id": "user-2"}},
+		{name: "missing", headers: headers},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: sahttp.JSSDKCookie, Value: tt.cookie})
+			}
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			got, ok := sahttp.FromSensorsSDK(tt.headers)(r)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("got %+v, %v, want %+v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseSensorsIdentityBothIDs(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: sahttp.JSSDKCookie, Value: url.PathEscape(`{"distinct_id":"user-1","first_id":"18a1b2c3","$device_id":"d1"}`)})
+	ids, ok := sahttp.ParseSensorsIdentity(r, sahttp.SensorsHeaders{})
+	if !ok || ids.AnonymousID != "18a1b2c3" || ids.LoginID != "user-1" || ids.DeviceID != "d1" {
+		t.Errorf("ParseSensorsIdentity() = %+v, %v", ids, ok)
+	}
+}
@@ -0,0 +1,81 @@
+package sensorsanalytics
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"path"
+)
+
+// SampleRateProperty 记录事件采样率的属性，分析时可按 1/采样率 还原事件数
+const SampleRateProperty = "$sample_rate"
+
+// SamplingRule 事件采样规则。采样只对 Track 生效，TrackSignup 及 Profile 操作不会被采样。
+type SamplingRule struct {
+	// Events 事件名称，支持 path.Match 通配符
+	Events []string
+	// Match 自定义匹配条件，与 Events 同时设置时需同时满足
+	Match func(eventName string, properties map[string]interface{}) bool
+	// Rate 采样率，取值 [0, 1]
+	Rate float64
+}
+
+func (r SamplingRule) matches(eventName string, properties map[string]interface{}) bool {
+	if len(r.Events) > 0 {
+		matched := false
+		for _, pattern := range r.Events {
+			if ok, _ := path.Match(pattern, eventName); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return r.Match == nil || r.Match(eventName, properties)
+}
+
+func validateSamplingRules(rules []SamplingRule) error {
+	for i, rule := range rules {
+		if len(rule.Events) == 0 && rule.Match == nil {
+			return fmt.Errorf("sampling rule [%d] must match events", i)
+		}
+		if rule.Rate < 0 || rule.Rate > 1 || math.IsNaN(rule.Rate) {
+			return fmt.Errorf("sampling rule [%d] rate must be between 0 and 1", i)
+		}
+		for _, pattern := range rule.Events {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("sampling rule [%d]: %s", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// sample 使用第一个匹配的规则判断是否发送事件，返回是否发送及采样率。
+// 同一个 distinct_id 在相同采样率下的结果总是相同的，保证用户的行为路径完整。
+func sample(rules []SamplingRule, distinctID string, eventName string, properties map[string]interface{}) (bool, float64, bool) {
+	for _, rule := range rules {
+		if !rule.matches(eventName, properties) {
+			continue
+		}
+		return samplePosition(distinctID) < rule.Rate, rule.Rate, true
+	}
+	return true, 1, false
+}
+
+// samplePosition 将 distinctID 映射为 [0, 1) 之间均匀分布的浮点数
+func samplePosition(distinctID string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(distinctID))
+	// FNV 的高位对相似的 ID (例如只有末尾数字不同) 分布不均，使用 murmur3 的 fmix64 打散
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	// 取高 53 位转换为 [0, 1) 之间的浮点数
+	return float64(x>>11) / float64(uint64(1)<<53)
+}
@@ -0,0 +1,157 @@
+package sensorsanalytics_test
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/satest"
+)
+
+func newSamplingClient(t *testing.T, rules ...sa.SamplingRule) (*sa.Client, *satest.RecordingConsumer) {
+	t.Helper()
+	rec := satest.NewRecordingConsumer()
+	client, err := sa.NewClient(rec, "default", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetSamplingRules(rules...); err != nil {
+		t.Fatal(err)
+	}
+	return client, rec
+}
+
+func TestSamplingPerDistinctID(t *testing.T) {
+	client, rec := newSamplingClient(t, sa.SamplingRule{Events: []string{"Page*"}, Rate: 0.5})
+	const users = 400
+	for i := 0; i < users; i++ {
+		id := fmt.Sprintf("user-%d", i)
+		client.Track(id, "PageView", nil, false)
+		client.Track(id, "PageLeave", nil, false)
+		client.Track(id, "OrderPaid", nil, false)
+	}
+
+	kept := map[string][]string{}
+	for _, e := range rec.Envelopes() {
+		kept[e.DistinctID] = append(kept[e.DistinctID], e.Event)
+		rate, sampled := e.Properties[sa.SampleRateProperty]
+		if e.Event == "OrderPaid" && sampled {
+			t.Errorf("unmatched event has %s = %v", sa.SampleRateProperty, rate)
+		}
+		if e.Event != "OrderPaid" && rate != 0.5 {
+			t.Errorf("%s has %s = %v, want 0.5", e.Event, sa.SampleRateProperty, rate)
+		}
+	}
+	// 同一用户的事件全部保留或全部丢弃，不匹配的事件总是保留
+	sampledUsers := 0
+	for id, events := range kept {
+		switch len(events) {
+		case 3:
+			sampledUsers++
+		case 1:
+			if events[0] != "OrderPaid" {
+				t.Errorf("user %s kept only %v", id, events)
+			}
+		default:
+			t.Errorf("user %s kept %v, want all or none of the sampled events", id, events)
+		}
+	}
+	if len(kept) != users {
+		t.Errorf("%d users have events, want %d", len(kept), users)
+	}
+	if ratio := float64(sampledUsers) / users; math.Abs(ratio-0.5) > 0.1 {
+		t.Errorf("kept %.2f of users, want about 0.5", ratio)
+	}
+	if got, want := client.SampledOutCount(), int64(2*(users-sampledUsers)); got != want {
+		t.Errorf("SampledOutCount() = %d, want %d", got, want)
+	}
+
+	// 相同的用户再次发送结果不变
+	rec.Reset()
+	for id, events := range kept {
+		client.Track(id, "PageView", nil, false)
+		if got := len(rec.Tracked("PageView")) == 1; got != (len(events) == 3) {
+			t.Errorf("user %s sampled differently the second time", id)
+		}
+		rec.Reset()
+	}
+}
+
+func TestSamplingFirstMatchingRule(t *testing.T) {
+	client, rec := newSamplingClient(t,
+		sa.SamplingRule{Events: []string{"PageView"}, Rate: 1},
+		sa.SamplingRule{
+			Events: []string{"Page*"},
+			Match: func(eventName string, properties map[string]interface{}) bool {
+				return properties["bot"] == true
+			},
+			Rate: 0,
+		},
+	)
+	client.Track("u1", "PageView", map[string]interface{}{"bot": true}, false)
+	client.Track("u1", "PageLeave", map[string]interface{}{"bot": true}, false)
+	client.Track("u1", "PageLeave", map[string]interface{}{"bot": false}, false)
+
+	satest.AssertTracked(t, rec, "PageView", map[string]interface{}{sa.SampleRateProperty: 1.0})
+	leaves := rec.Tracked("PageLeave")
+	if len(leaves) != 1 || leaves[0].Properties["bot"] != false {
+		t.Errorf("PageLeave events %+v, want only the one from a non-bot", leaves)
+	}
+	if _, ok := leaves[0].Properties[sa.SampleRateProperty]; ok {
+		t.Error("unmatched PageLeave has a sample rate")
+	}
+}
+
+func TestSamplingExemptsProfilesAndSignup(t *testing.T) {
+	client, rec := newSamplingClient(t, sa.SamplingRule{
+		Match: func(string, map[string]interface{}) bool { return true },
+		Rate:  0,
+	})
+	props := map[string]interface{}{"plan": "pro"}
+	calls := []error{
+		client.Track("u1", "PageView", nil, false),
+		client.TrackSignup("u1", "anon-1", nil),
+		client.ProfileSet("u1", props, true),
+		client.ProfileSetOnce("u1", props, true),
+		client.ProfileIncrement("u1", map[string]interface{}{"orders": 1}, true),
+		client.ProfileAppend("u1", map[string]interface{}{"tags": []string{"a"}}, true),
+		client.ProfileUnset("u1", []string{"plan"}, true),
+		client.ProfileDelete("u1", true),
+	}
+	for i, err := range calls {
+		if err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+	}
+	satest.AssertNotTracked(t, rec, "PageView")
+	var types []string
+	for _, e := range rec.Envelopes() {
+		types = append(types, e.Type)
+	}
+	want := []string{"track_signup", "profile_set", "profile_set_once", "profile_increment", "profile_append", "profile_unset", "profile_delete"}
+	if fmt.Sprint(types) != fmt.Sprint(want) {
+		t.Errorf("sent %v, want %v", types, want)
+	}
+	if client.SampledOutCount() != 1 {
+		t.Errorf("SampledOutCount() = %d, want 1", client.SampledOutCount())
+	}
+}
+
+func TestSetSamplingRulesValidation(t *testing.T) {
+	client, err := sa.NewClient(satest.NewRecordingConsumer(), "default", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rule := range []sa.SamplingRule{
+		{Rate: 0.5},
+		{Events: []string{"PageView"}, Rate: 1.5},
+		{Events: []string{"PageView"}, Rate: -0.1},
+		{Events: []string{"PageView"}, Rate: math.NaN()},
+		{Events: []string{"Page["}, Rate: 0.5},
+	} {
+		if err := client.SetSamplingRules(rule); err == nil {
+			t.Errorf("SetSamplingRules(%+v) = nil, want an error", rule)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package satest
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// AssertTracked 断言记录了事件 eventName，且至少一条该事件包含 props 中的所有属性
+func AssertTracked(t testing.TB, rec *RecordingConsumer, eventName string, props map[string]interface{}) bool {
+	t.Helper()
+	tracked := rec.Tracked(eventName)
+	if len(tracked) == 0 {
+		t.Errorf("event %q was not tracked, got %d envelopes", eventName, len(rec.Envelopes()))
+		return false
+	}
+	for _, e := range tracked {
+		if containsProperties(e.Properties, props) {
+			return true
+		}
+	}
+	t.Errorf("event %q was tracked %d times but none has properties %v, last: %v", eventName, len(tracked), props, tracked[len(tracked)-1].Properties)
+	return false
+}
+
+// AssertNotTracked 断言没有记录事件 eventName
+func AssertNotTracked(t testing.TB, rec *RecordingConsumer, eventName string) bool {
+	t.Helper()
+	if tracked := rec.Tracked(eventName); len(tracked) > 0 {
+		t.Errorf("event %q was tracked %d times", eventName, len(tracked))
+		return false
+	}
+	return true
+}
+
+// AssertProfileSet 断言为用户 distinctID 发送了包含 props 中所有属性的 profile_set
+func AssertProfileSet(t testing.TB, rec *RecordingConsumer, distinctID string, props map[string]interface{}) bool {
+	t.Helper()
+	profiles := rec.OfType("profile_set")
+	for _, e := range profiles {
+		if e.DistinctID == distinctID && containsProperties(e.Properties, props) {
+			return true
+		}
+	}
+	t.Errorf("no profile_set for %q with properties %v in %d profile_set envelopes", distinctID, props, len(profiles))
+	return false
+}
+
+// AssertNoEvents 断言没有记录任何数据
+func AssertNoEvents(t testing.TB, rec *RecordingConsumer) bool {
+	t.Helper()
+	if envelopes := rec.Envelopes(); len(envelopes) > 0 {
+		t.Errorf("expected no events, got %d, first: %s %s", len(envelopes), envelopes[0].Type, envelopes[0].Event)
+		return false
+	}
+	return true
+}
+
+// containsProperties 判断 actual 是否包含 expected 中的所有属性，expected 按 JSON 编码后比较，
+// 因此 int 10 与解码得到的 float64 10 相等
+func containsProperties(actual map[string]interface{}, expected map[string]interface{}) bool {
+	if len(expected) == 0 {
+		return true
+	}
+	b, err := json.Marshal(expected)
+	if err != nil {
+		return false
+	}
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(b, &normalized); err != nil {
+		return false
+	}
+	for k, v := range normalized {
+		if av, ok := actual[k]; !ok || !reflect.DeepEqual(av, v) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package satest_test
+
+import (
+	"fmt"
+	"testing"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/satest"
+)
+
+// recordingTB 记录断言失败信息而不使测试失败
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (tb *recordingTB) Helper() {}
+
+func (tb *recordingTB) Errorf(format string, args ...interface{}) {
+	tb.errors = append(tb.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertions(t *testing.T) {
+	rec := satest.NewRecordingConsumer()
+	clt, _ := sa.NewClient(rec, "default", false)
+	clt.Track("u1", "OrderPaid", map[string]interface{}{"amount": 10, "currency": "CNY"}, false)
+	clt.ProfileSet("u1", map[string]interface{}{"VIP": true}, false)
+	empty := satest.NewRecordingConsumer()
+
+	tests := []struct {
+		name   string
+		assert func(tb testing.TB) bool
+		want   bool
+	}{
+		{"tracked", func(tb testing.TB) bool { return satest.AssertTracked(tb, rec, "OrderPaid", nil) }, true},
+		{"tracked with int property", func(tb testing.TB) bool {
+			return satest.AssertTracked(tb, rec, "OrderPaid", map[string]interface{}{"amount": 10})
+		}, true},
+		{"tracked with wrong property", func(tb testing.TB) bool {
+			return satest.AssertTracked(tb, rec, "OrderPaid", map[string]interface{}{"currency": "USD"})
+		}, false},
+		{"tracked missing event", func(tb testing.TB) bool { return satest.AssertTracked(tb, rec, "OrderRefunded", nil) }, false},
+		{"not tracked", func(tb testing.TB) bool { return satest.AssertNotTracked(tb, rec, "OrderRefunded") }, true},
+		{"not tracked but tracked", func(tb testing.TB) bool { return satest.AssertNotTracked(tb, rec, "OrderPaid") }, false},
+		{"profile set", func(tb testing.TB) bool {
+			return satest.AssertProfileSet(tb, rec, "u1", map[string]interface{}{"VIP": true})
+		}, true},
+		{"profile set other user", func(tb testing.TB) bool {
+			return satest.AssertProfileSet(tb, rec, "u2", nil)
+		}, false},
+		{"no events", func(tb testing.TB) bool { return satest.AssertNoEvents(tb, empty) }, true},
+		{"no events but recorded", func(tb testing.TB) bool { return satest.AssertNoEvents(tb, rec) }, false},
+	}
+	for _, tt := range tests {
+		tb := &recordingTB{TB: t}
+		got := tt.assert(tb)
+		if got != tt.want {
+			t.Errorf("%s: returned %v, want %v", tt.name, got, tt.want)
+		}
+		if failed := len(tb.errors) > 0; failed == tt.want {
+			t.Errorf("%s: reported errors %q", tt.name, tb.errors)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package satest
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock 只在手动调整时变化的时钟，通过 Client.SetClock 使事件时间固定
+type FakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+// NewFakeClock 创建新的 FakeClock
+// :param now: 初始时间
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now 返回当前时间
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// Set 设置当前时间
+func (c *FakeClock) Set(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = now
+}
+
+// Advance 将当前时间向后调整 d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Millis 返回当前时间对应的事件 time 字段的值
+func (c *FakeClock) Millis() int64 {
+	return c.Now().Unix() * 1000
+}
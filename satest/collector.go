@@ -0,0 +1,189 @@
+package satest
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+// CollectorRequest Collector 收到的一个请求
+type CollectorRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	// Debug 是否为 /debug 请求
+	Debug bool
+	// DryRun 是否带有 Dry-Run: true，此时数据只检查不保存
+	DryRun bool
+	// Gzip 数据是否经过 gzip 压缩
+	Gzip bool
+	// Messages 请求中的数据条数
+	Messages int
+	// StatusCode 返回的状态码
+	StatusCode int
+	// Err 解码或检查数据时的错误
+	Err error
+}
+
+// CollectorDebugResponse /debug 检查数据失败时返回的内容
+type CollectorDebugResponse struct {
+	Error   string          `json:"error"`
+	Details []sa.DebugField `json:"details"`
+}
+
+// Collector 运行在进程内的模拟数据接收服务器，与真实服务器使用相同的协议:
+// GET/POST 的 data 或 data_list 参数，base64 编码，可能经过 gzip 压缩，
+// /debug 路径返回检查结果，Dry-Run: true 时只检查不保存。
+type Collector struct {
+	server     *httptest.Server
+	lock       sync.Mutex
+	events     []Envelope
+	requests   []CollectorRequest
+	status     int
+	latency    time.Duration
+	failNext   int
+	failStatus int
+}
+
+// NewCollector 创建并启动新的 Collector，使用完毕后需调用 Close
+func NewCollector() *Collector {
+	c := &Collector{}
+	c.server = httptest.NewServer(http.HandlerFunc(c.handle))
+	return c
+}
+
+// URL 返回 Consumer 使用的服务器地址
+func (c *Collector) URL() string {
+	return c.server.URL + "/sa?project=default"
+}
+
+// Close 关闭服务器
+func (c *Collector) Close() {
+	c.server.Close()
+}
+
+// SetStatus 设置所有请求返回的状态码，为 0 时恢复正常处理
+func (c *Collector) SetStatus(statusCode int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.status = statusCode
+}
+
+// SetLatency 设置每个请求返回前等待的时间
+func (c *Collector) SetLatency(latency time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.latency = latency
+}
+
+// FailNext 之后的 n 个请求返回 statusCode 且不保存数据
+func (c *Collector) FailNext(n int, statusCode int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.failNext = n
+	c.failStatus = statusCode
+}
+
+// Events 返回保存的所有数据
+func (c *Collector) Events() []Envelope {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	events := make([]Envelope, len(c.events))
+	copy(events, c.events)
+	return events
+}
+
+// Requests 返回收到的所有请求
+func (c *Collector) Requests() []CollectorRequest {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	requests := make([]CollectorRequest, len(c.requests))
+	copy(requests, c.requests)
+	return requests
+}
+
+// Reset 清空保存的数据及请求记录
+func (c *Collector) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.events = nil
+	c.requests = nil
+}
+
+func (c *Collector) handle(w http.ResponseWriter, r *http.Request) {
+	c.lock.Lock()
+	latency := c.latency
+	status := c.status
+	if status == 0 && c.failNext > 0 {
+		c.failNext--
+		status = c.failStatus
+	}
+	c.lock.Unlock()
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+
+	req := CollectorRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Header: r.Header.Clone(),
+		Debug:  r.URL.Path == "/debug",
+		DryRun: strings.EqualFold(r.Header.Get("Dry-Run"), "true"),
+	}
+	var events []sa.Event
+	if status == 0 {
+		events, req.Err = sa.DecodeRequest(r)
+		req.Gzip = r.Form.Get("gzip") == "1"
+		req.Messages = len(events)
+		if req.Err == nil {
+			for _, e := range events {
+				if req.Err = sa.ValidateMessage(e.Raw); req.Err != nil {
+					break
+				}
+			}
+		}
+		status = http.StatusOK
+		if req.Err != nil {
+			status = http.StatusBadRequest
+		}
+	}
+	req.StatusCode = status
+
+	c.lock.Lock()
+	c.requests = append(c.requests, req)
+	if status == http.StatusOK && !req.DryRun {
+		for _, e := range events {
+			if envelope, err := DecodeEnvelope(e.Raw); err == nil {
+				c.events = append(c.events, envelope)
+			}
+		}
+	}
+	c.lock.Unlock()
+
+	if req.Debug && req.Err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(debugResponse(req.Err))
+		return
+	}
+	w.WriteHeader(status)
+}
+
+func debugResponse(err error) CollectorDebugResponse {
+	resp := CollectorDebugResponse{Error: err.Error()}
+	var validationErr *sa.ValidationError
+	if errors.As(err, &validationErr) {
+		resp.Error = validationErr.Message
+		resp.Details = append(resp.Details, sa.DebugField{
+			Field:   validationErr.Field,
+			Message: validationErr.Message,
+		})
+	}
+	return resp
+}
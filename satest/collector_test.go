@@ -0,0 +1,161 @@
+package satest_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/satest"
+)
+
+func TestCollector(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(c *satest.Collector)
+		// send 发送数据，返回各次发送的结果
+		send         func(t *testing.T, url string) []error
+		wantRequests []satest.CollectorRequest
+		wantEvents   int
+	}{
+		{
+			name: "single message",
+			send: func(t *testing.T, url string) []error {
+				consumer, _ := sa.NewDefaultConsumer(url)
+				return []error{consumer.Send(trackMsg("u1", "OrderPaid"))}
+			},
+			wantRequests: []satest.CollectorRequest{{Method: "GET", Path: "/sa", Messages: 1, StatusCode: 200}},
+			wantEvents:   1,
+		},
+		{
+			name: "gzip batch",
+			send: func(t *testing.T, url string) []error {
+				return []error{postGzipDataList(url, trackMsg("u1", "OrderPaid"), trackMsg("u2", "OrderPaid"), trackMsg("u3", "OrderPaid"))}
+			},
+			wantRequests: []satest.CollectorRequest{{Method: "POST", Path: "/sa", Gzip: true, Messages: 3, StatusCode: 200}},
+			wantEvents:   3,
+		},
+		{
+			name: "debug dry run",
+			send: func(t *testing.T, url string) []error {
+				consumer, _ := sa.NewDebugConsumer(url, false)
+				return []error{consumer.Send(trackMsg("u1", "OrderPaid"))}
+			},
+			wantRequests: []satest.CollectorRequest{{Method: "GET", Path: "/debug", Debug: true, DryRun: true, Messages: 1, StatusCode: 200}},
+		},
+		{
+			name: "debug rejects invalid message",
+			send: func(t *testing.T, url string) []error {
+				consumer, _ := sa.NewDebugConsumer(url, true)
+				err := consumer.Send(trackMsg("", "OrderPaid"))
+				var debugErr *sa.DebugError
+				if !errors.As(err, &debugErr) || len(debugErr.Fields) != 1 || debugErr.Fields[0].Field != "distinct_id" {
+					t.Errorf("Send() = %#v, want *DebugError for distinct_id", err)
+				}
+				return nil
+			},
+			wantRequests: []satest.CollectorRequest{{Method: "GET", Path: "/debug", Debug: true, Messages: 1, StatusCode: 400}},
+		},
+		{
+			name:  "fail next",
+			setup: func(c *satest.Collector) { c.FailNext(1, http.StatusServiceUnavailable) },
+			send: func(t *testing.T, url string) []error {
+				consumer, _ := sa.NewDefaultConsumer(url)
+				first := consumer.Send(trackMsg("u1", "OrderPaid"))
+				if first == nil {
+					t.Error("first Send() succeeded, want 503")
+				}
+				return []error{consumer.Send(trackMsg("u1", "OrderPaid"))}
+			},
+			wantRequests: []satest.CollectorRequest{
+				{Method: "GET", Path: "/sa", StatusCode: 503},
+				{Method: "GET", Path: "/sa", Messages: 1, StatusCode: 200},
+			},
+			wantEvents: 1,
+		},
+		{
+			name:  "status",
+			setup: func(c *satest.Collector) { c.SetStatus(http.StatusInternalServerError) },
+			send: func(t *testing.T, url string) []error {
+				consumer, _ := sa.NewDefaultConsumer(url)
+				var statusErr *sa.StatusError
+				if err := consumer.Send(trackMsg("u1", "OrderPaid")); !errors.As(err, &statusErr) || statusErr.StatusCode != 500 {
+					t.Errorf("Send() = %v, want status 500", err)
+				}
+				return nil
+			},
+			wantRequests: []satest.CollectorRequest{{Method: "GET", Path: "/sa", StatusCode: 500}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := satest.NewCollector()
+			defer c.Close()
+			if tt.setup != nil {
+				tt.setup(c)
+			}
+			for i, err := range tt.send(t, c.URL()) {
+				if err != nil {
+					t.Errorf("send %d: %s", i, err)
+				}
+			}
+			requests := c.Requests()
+			if len(requests) != len(tt.wantRequests) {
+				t.Fatalf("got %d requests, want %d: %+v", len(requests), len(tt.wantRequests), requests)
+			}
+			for i, want := range tt.wantRequests {
+				got := requests[i]
+				got.Header = nil
+				got.Err = nil
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("request %d: got %+v, want %+v", i, got, want)
+				}
+			}
+			if got := len(c.Events()); got != tt.wantEvents {
+				t.Errorf("got %d events, want %d", got, tt.wantEvents)
+			}
+		})
+	}
+}
+
+// postGzipDataList 按接收服务器的协议以 gzip 压缩的 data_list 发送数据
+func postGzipDataList(serverURL string, msgs ...map[string]interface{}) error {
+	b, err := json.Marshal(msgs)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	zw.Close()
+	form := url.Values{"data_list": {base64.StdEncoding.EncodeToString(buf.Bytes())}, "gzip": {"1"}}
+	resp, err := http.PostForm(serverURL, form)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func TestCollectorReset(t *testing.T) {
+	c := satest.NewCollector()
+	defer c.Close()
+	consumer, _ := sa.NewDefaultConsumer(c.URL())
+	if err := consumer.Send(trackMsg("u1", "OrderPaid")); err != nil {
+		t.Fatal(err)
+	}
+	c.Reset()
+	if len(c.Events()) != 0 || len(c.Requests()) != 0 {
+		t.Errorf("after Reset: %d events, %d requests", len(c.Events()), len(c.Requests()))
+	}
+}
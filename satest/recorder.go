@@ -0,0 +1,181 @@
+// Package satest 提供测试埋点代码使用的 Consumer、断言及时钟。
+package satest
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Envelope 一条经过 JSON 编码再解码的数据，与服务器收到的内容一致
+type Envelope struct {
+	Type       string
+	Event      string
+	DistinctID string
+	OriginalID string
+	Project    string
+	Time       int64
+	TimeFree   bool
+	Properties map[string]interface{}
+	Lib        map[string]interface{}
+	// Raw 解码后的完整数据
+	Raw map[string]interface{}
+}
+
+// DecodeEnvelope 将 Consumer.Send 收到的数据按发送时的方式编码后解码
+func DecodeEnvelope(msg map[string]interface{}) (Envelope, error) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return Envelope{}, err
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return Envelope{}, err
+	}
+	var e struct {
+		Type       string                 `json:"type"`
+		Event      string                 `json:"event"`
+		DistinctID string                 `json:"distinct_id"`
+		OriginalID string                 `json:"original_id"`
+		Project    string                 `json:"project"`
+		Time       int64                  `json:"time"`
+		TimeFree   bool                   `json:"time_free"`
+		Properties map[string]interface{} `json:"properties"`
+		Lib        map[string]interface{} `json:"lib"`
+	}
+	if err := json.Unmarshal(b, &e); err != nil {
+		return Envelope{}, err
+	}
+	return Envelope{
+		Type:       e.Type,
+		Event:      e.Event,
+		DistinctID: e.DistinctID,
+		OriginalID: e.OriginalID,
+		Project:    e.Project,
+		Time:       e.Time,
+		TimeFree:   e.TimeFree,
+		Properties: e.Properties,
+		Lib:        e.Lib,
+		Raw:        raw,
+	}, nil
+}
+
+// RecordingConsumer 记录收到的所有数据的 Consumer，可以并发使用
+type RecordingConsumer struct {
+	lock      sync.Mutex
+	envelopes []Envelope
+	err       error
+	flushes   int
+	closes    int
+}
+
+// NewRecordingConsumer 创建新的 RecordingConsumer
+func NewRecordingConsumer() *RecordingConsumer {
+	return &RecordingConsumer{}
+}
+
+// Send 记录数据，设置了 SetError 时返回该错误且不记录
+func (c *RecordingConsumer) Send(msg map[string]interface{}) error {
+	envelope, err := DecodeEnvelope(msg)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.envelopes = append(c.envelopes, envelope)
+	return nil
+}
+
+// Flush 记录 Flush 的调用次数
+func (c *RecordingConsumer) Flush() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.flushes++
+	return nil
+}
+
+// Close 记录 Close 的调用次数
+func (c *RecordingConsumer) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.closes++
+	return nil
+}
+
+// Purge 删除用户 distinctID 的数据，与缓存数据的 Consumer 行为一致
+func (c *RecordingConsumer) Purge(distinctID string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	kept := c.envelopes[:0]
+	for _, e := range c.envelopes {
+		if e.DistinctID != distinctID {
+			kept = append(kept, e)
+		}
+	}
+	purged := len(c.envelopes) - len(kept)
+	c.envelopes = kept
+	return purged
+}
+
+// SetError 设置 Send 返回的错误，用于测试发送失败的情况，为 nil 时恢复正常
+func (c *RecordingConsumer) SetError(err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.err = err
+}
+
+// Envelopes 返回记录的所有数据
+func (c *RecordingConsumer) Envelopes() []Envelope {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	envelopes := make([]Envelope, len(c.envelopes))
+	copy(envelopes, c.envelopes)
+	return envelopes
+}
+
+// Tracked 返回事件名称为 eventName 的 track 及 track_signup 数据
+func (c *RecordingConsumer) Tracked(eventName string) []Envelope {
+	var tracked []Envelope
+	for _, e := range c.Envelopes() {
+		if (e.Type == "track" || e.Type == "track_signup") && e.Event == eventName {
+			tracked = append(tracked, e)
+		}
+	}
+	return tracked
+}
+
+// OfType 返回类型为 msgType 的数据，例如 "profile_set"
+func (c *RecordingConsumer) OfType(msgType string) []Envelope {
+	var envelopes []Envelope
+	for _, e := range c.Envelopes() {
+		if e.Type == msgType {
+			envelopes = append(envelopes, e)
+		}
+	}
+	return envelopes
+}
+
+// Flushes 返回 Flush 的调用次数
+func (c *RecordingConsumer) Flushes() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.flushes
+}
+
+// Closes 返回 Close 的调用次数
+func (c *RecordingConsumer) Closes() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.closes
+}
+
+// Reset 清空记录的数据
+func (c *RecordingConsumer) Reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.envelopes = nil
+	c.flushes = 0
+	c.closes = 0
+}
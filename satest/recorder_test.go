@@ -0,0 +1,130 @@
+package satest_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/satest"
+)
+
+func trackMsg(distinctID string, event string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "track",
+		"event":       event,
+		"distinct_id": distinctID,
+		"time":        time.Now().Unix() * 1000,
+		"properties":  map[string]interface{}{"amount": 10},
+	}
+}
+
+func TestRecordingConsumer(t *testing.T) {
+	rec := satest.NewRecordingConsumer()
+	clt, _ := sa.NewClient(rec, "default", false)
+	clock := satest.NewFakeClock(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	clt.SetClock(clock)
+
+	clt.Track("u1", "OrderPaid", map[string]interface{}{"amount": 10}, false)
+	clock.Advance(time.Minute)
+	clt.Track("u2", "OrderPaid", nil, true)
+	clt.ProfileSet("u1", map[string]interface{}{"VIP": true}, false)
+	clt.Flush()
+
+	envelopes := rec.Envelopes()
+	if len(envelopes) != 3 {
+		t.Fatalf("got %d envelopes, want 3", len(envelopes))
+	}
+	tests := []struct {
+		name  string
+		got   []satest.Envelope
+		wantN int
+	}{
+		{"Tracked", rec.Tracked("OrderPaid"), 2},
+		{"Tracked other", rec.Tracked("OrderRefunded"), 0},
+		{"OfType profile_set", rec.OfType("profile_set"), 1},
+		{"OfType track", rec.OfType("track"), 2},
+	}
+	for _, tt := range tests {
+		if len(tt.got) != tt.wantN {
+			t.Errorf("%s: got %d, want %d", tt.name, len(tt.got), tt.wantN)
+		}
+	}
+	first, second := envelopes[0], envelopes[1]
+	if first.Time != time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Unix()*1000 {
+		t.Errorf("first time = %d", first.Time)
+	}
+	if second.Time-first.Time != 60000 || second.Time != clock.Millis() {
+		t.Errorf("second time = %d, want %d", second.Time, clock.Millis())
+	}
+	// 属性经过 JSON 编码后解码，数字为 float64
+	if first.Properties["amount"] != float64(10) || second.Properties["$is_login_id"] != true {
+		t.Errorf("properties: %v, %v", first.Properties, second.Properties)
+	}
+	if rec.Flushes() != 1 || rec.Closes() != 0 {
+		t.Errorf("Flushes() = %d, Closes() = %d", rec.Flushes(), rec.Closes())
+	}
+
+	if n := rec.Purge("u1"); n != 2 || len(rec.Envelopes()) != 1 {
+		t.Errorf("Purge(u1) = %d, %d left", n, len(rec.Envelopes()))
+	}
+
+	sendErr := errors.New("down")
+	rec.SetError(sendErr)
+	if err := clt.Track("u3", "OrderPaid", nil, false); !errors.Is(err, sendErr) {
+		t.Errorf("Track with SetError: %v", err)
+	}
+	rec.SetError(nil)
+	clt.Close()
+	if len(rec.Envelopes()) != 1 || rec.Closes() != 1 {
+		t.Errorf("after SetError: %d envelopes, %d closes", len(rec.Envelopes()), rec.Closes())
+	}
+	rec.Reset()
+	if len(rec.Envelopes()) != 0 || rec.Flushes() != 0 || rec.Closes() != 0 {
+		t.Error("Reset did not clear the recording")
+	}
+}
+
+func TestRecordingConsumerConcurrent(t *testing.T) {
+	rec := satest.NewRecordingConsumer()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				rec.Send(trackMsg("u1", "OrderPaid"))
+				rec.Tracked("OrderPaid")
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(rec.Envelopes()); n != 800 {
+		t.Errorf("got %d envelopes, want 800", n)
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 600e6, time.UTC)
+	clock := satest.NewFakeClock(start)
+	tests := []struct {
+		name   string
+		change func()
+		want   time.Time
+	}{
+		{"initial", func() {}, start},
+		{"advance", func() { clock.Advance(time.Hour) }, start.Add(time.Hour)},
+		{"set", func() { clock.Set(start.Add(-time.Hour)) }, start.Add(-time.Hour)},
+	}
+	for _, tt := range tests {
+		tt.change()
+		if got := clock.Now(); !got.Equal(tt.want) {
+			t.Errorf("%s: Now() = %s, want %s", tt.name, got, tt.want)
+		}
+		// Millis 与事件的 time 字段一致，只精确到秒
+		if got := clock.Millis(); got != tt.want.Unix()*1000 {
+			t.Errorf("%s: Millis() = %d", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package sensorsanalytics
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTransport 所有未指定 http.Client 的 Consumer 共用的 keep-alive 连接池
+var defaultTransport http.RoundTripper = newHTTPTransport(HTTPConfig{})
+
+// defaultHTTPClient 所有未指定 http.Client 的 Consumer 共用的 http.Client
+var defaultHTTPClient = &http.Client{
+	Transport: defaultTransport,
+	Timeout:   DefaultTimeout,
+}
+
+// HTTPConfig 创建 http.Client 的配置，零值字段使用默认值
+type HTTPConfig struct {
+	// Timeout 单次请求的超时时间，包括连接、发送及读取返回
+	Timeout time.Duration
+	// DialTimeout 建立连接的超时时间
+	DialTimeout time.Duration
+	// MaxIdleConnsPerHost 每个服务器保持的空闲连接数
+	MaxIdleConnsPerHost int
+	// Proxy 代理设置，为 nil 时使用环境变量 HTTP_PROXY/HTTPS_PROXY
+	Proxy func(*http.Request) (*url.URL, error)
+	// TLSConfig 自定义 CA、客户端证书等 TLS 设置
+	TLSConfig *tls.Config
+}
+
+// NewHTTPClient 根据配置创建 http.Client，可通过 SetHTTPClient 设置给 Consumer
+func NewHTTPClient(config HTTPConfig) *http.Client {
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &http.Client{
+		Transport: newHTTPTransport(config),
+		Timeout:   timeout,
+	}
+}
+
+func newHTTPTransport(config HTTPConfig) *http.Transport {
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = DefaultDialTimeout
+	}
+	if config.MaxIdleConnsPerHost <= 0 {
+		config.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+	if config.Proxy == nil {
+		config.Proxy = http.ProxyFromEnvironment
+	}
+	return &http.Transport{
+		Proxy: config.Proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   config.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       config.TLSConfig,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   config.DialTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// RequestDecorator 在请求发出前对其进行修改，例如添加 API 网关需要的鉴权 header。
+// 返回错误时放弃发送，该错误原样返回给调用方，不会重试或切换服务器地址
+type RequestDecorator func(req *http.Request) error
+
+// HeaderDecorator 返回为每个请求设置固定 header 的 RequestDecorator
+func HeaderDecorator(header http.Header) RequestDecorator {
+	return func(req *http.Request) error {
+		for k, vs := range header {
+			req.Header.Del(k)
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+		return nil
+	}
+}
+
+// transport 负责将请求发送给服务器，被各个 Consumer 共用
+type transport struct {
+	lock       sync.RWMutex
+	client     *http.Client
+	decorators []RequestDecorator
+}
+
+// SetHTTPClient 设置发送数据使用的 http.Client，为 nil 时使用默认的 http.Client
+func (t *transport) SetHTTPClient(client *http.Client) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.client = client
+}
+
+// SetTransport 使用指定的 http.RoundTripper 及默认超时时间发送数据
+func (t *transport) SetTransport(rt http.RoundTripper) {
+	t.SetHTTPClient(&http.Client{
+		Transport: rt,
+		Timeout:   DefaultTimeout,
+	})
+}
+
+// AddRequestDecorator 添加请求发出前调用的 RequestDecorator，按添加顺序执行
+func (t *transport) AddRequestDecorator(decorator RequestDecorator) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.decorators = append(t.decorators, decorator)
+}
+
+// do 执行 RequestDecorator 并发送请求。RequestDecorator 的错误原样返回，
+// 发送失败时返回 ErrNetworkException，以便区分是否可以重试
+func (t *transport) do(req *http.Request) (*http.Response, error) {
+	t.lock.RLock()
+	client := t.client
+	decorators := t.decorators
+	t.lock.RUnlock()
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	for _, decorator := range decorators {
+		if err := decorator(req); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNetworkException, err)
+	}
+	return resp, nil
+}
+
+// sendData 以 GET 请求发送单条数据
+func (t *transport) sendData(serverURL string, data string, message string, debug bool) error {
+	req, err := http.NewRequest("GET", serverURL, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNetworkException, err)
+	}
+	q := req.URL.Query()
+	q.Add("data", data)
+	req.URL.RawQuery = q.Encode()
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	return t.send(req, message, debug)
+}
+
+// sendDataList 以 POST 请求发送一批数据，compressed 表示 dataList 经过 gzip 压缩
+func (t *transport) sendDataList(serverURL string, dataList string, compressed bool, message string, debug bool) error {
+	q := url.Values{}
+	q.Add("data_list", dataList)
+	if compressed {
+		q.Add("gzip", "1")
+	}
+	req, err := http.NewRequest("POST", serverURL, strings.NewReader(q.Encode()))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNetworkException, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return t.send(req, message, debug)
+}
+
+func (t *transport) send(req *http.Request, message string, debug bool) error {
+	resp, err := t.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil && debug {
+		log.Printf("read response body: %s", err)
+	}
+	if debug {
+		log.Printf("message: %s", message)
+		log.Printf("ret_code: %d", resp.StatusCode)
+		log.Printf("resp content: %s", string(body))
+	}
+	if resp.StatusCode != 200 {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package sensorsanalytics_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+)
+
+// headerServer 记录每个请求的 header
+type headerServer struct {
+	*httptest.Server
+	lock    sync.Mutex
+	headers []http.Header
+}
+
+func newHeaderServer() *headerServer {
+	s := &headerServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		s.headers = append(s.headers, r.Header.Clone())
+		s.lock.Unlock()
+	}))
+	return s
+}
+
+func (s *headerServer) received() []http.Header {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]http.Header(nil), s.headers...)
+}
+
+func TestRequestDecoratorsRunInOrder(t *testing.T) {
+	srv := newHeaderServer()
+	defer srv.Close()
+	consumer, err := sa.NewDefaultConsumer(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	for _, name := range []string{"first", "second", "third"} {
+		name := name
+		consumer.AddRequestDecorator(func(req *http.Request) error {
+			order = append(order, name)
+			req.Header.Add("X-Trace", name)
+			return nil
+		})
+	}
+	if err := consumer.Send(trackMsg("u1", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(order, ",") != "first,second,third" {
+		t.Errorf("decorators ran in order %v", order)
+	}
+	headers := srv.received()
+	if len(headers) != 1 || strings.Join(headers[0].Values("X-Trace"), ",") != "first,second,third" {
+		t.Errorf("received headers %v", headers)
+	}
+}
+
+func TestHeaderDecoratorReplacesHeader(t *testing.T) {
+	srv := newHeaderServer()
+	defer srv.Close()
+	consumer, err := sa.NewBatchConsumer(srv.URL, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer.AddRequestDecorator(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer stale")
+		return nil
+	})
+	consumer.AddRequestDecorator(sa.HeaderDecorator(http.Header{
+		"Authorization": {"Bearer fresh"},
+		"X-Tenant":      {"a", "b"},
+	}))
+	consumer.Send(trackMsg("u1", nil))
+	if err := consumer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	headers := srv.received()
+	if len(headers) != 1 {
+		t.Fatalf("received %d requests, want 1", len(headers))
+	}
+	if got := headers[0].Values("Authorization"); len(got) != 1 || got[0] != "Bearer fresh" {
+		t.Errorf("Authorization %v, want only the decorator's value", got)
+	}
+	if got := headers[0].Values("X-Tenant"); strings.Join(got, ",") != "a,b" {
+		t.Errorf("X-Tenant %v, want both values", got)
+	}
+}
+
+func TestRequestDecoratorErrorAbortsSend(t *testing.T) {
+	srv := newHeaderServer()
+	defer srv.Close()
+	consumer, err := sa.NewDefaultConsumer(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errToken := errors.New("token expired")
+	called := false
+	consumer.AddRequestDecorator(func(req *http.Request) error { return errToken })
+	consumer.AddRequestDecorator(func(req *http.Request) error {
+		called = true
+		return nil
+	})
+	if err := consumer.Send(trackMsg("u1", nil)); err != errToken {
+		t.Errorf("Send() = %v, want the decorator's error", err)
+	}
+	if called {
+		t.Error("later decorator ran after an error")
+	}
+	if n := len(srv.received()); n != 0 {
+		t.Errorf("server received %d requests, want 0", n)
+	}
+}
+
+func TestSetHTTPClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+	consumer, err := sa.NewDefaultConsumer(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumer.SetHTTPClient(sa.NewHTTPClient(sa.HTTPConfig{Timeout: 20 * time.Millisecond}))
+	if err := consumer.Send(trackMsg("u1", nil)); !errors.Is(err, sa.ErrNetworkException) {
+		t.Errorf("Send() = %v, want a network error after the client's timeout", err)
+	}
+
+	var used bool
+	consumer.SetTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		used = true
+		return http.DefaultTransport.RoundTrip(req)
+	}))
+	if err := consumer.Send(trackMsg("u1", nil)); err != nil || !used {
+		t.Errorf("Send() = %v, used %v, want the custom transport", err, used)
+	}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
@@ -0,0 +1,173 @@
+package sensorsanalytics
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+)
+
+// namePattern 事件名、项目名及属性名必须是合法的变量名
+var namePattern = regexp.MustCompile("^([a-zA-Z_$][a-zA-Z0-9_$]{0,99}$)")
+
+// ValidationError 数据格式有误，可以通过 errors.Is(err, ErrIllegalDataException) 判断
+type ValidationError struct {
+	// Field 有误的字段，例如 "distinct_id"、"properties.amount"
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrIllegalDataException, e.Message)
+}
+
+// Unwrap 返回 ErrIllegalDataException
+func (e *ValidationError) Unwrap() error {
+	return ErrIllegalDataException
+}
+
+func invalid(field string, format string, args ...interface{}) error {
+	return &ValidationError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// isValidName 判断是否为合法的变量名且不是保留字段
+func isValidName(input string) bool {
+	if namePattern.MatchString(input) {
+		for _, keyword := range FieldKeywords {
+			if keyword == input {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// ValidateMessage 按服务器的规则检查一条数据，不修改 msg。
+// msg 可以是 Client 生成的数据，也可以是从 JSON 解码得到的数据。
+func ValidateMessage(msg map[string]interface{}) error {
+	_, err := NormalizeMessage(msg)
+	return err
+}
+
+// NormalizeMessage 按服务器的规则检查一条数据，返回将 time 转换为毫秒级 int64 后的副本，不修改 msg。
+// 秒级的 time 会被转换为毫秒。
+func NormalizeMessage(msg map[string]interface{}) (map[string]interface{}, error) {
+	copied := make(map[string]interface{}, len(msg))
+	for k, v := range msg {
+		copied[k] = v
+	}
+	return normalizeMessage(copied)
+}
+
+// normalizeMessage 检查数据格式，并将 time 转换为毫秒级的 int64
+func normalizeMessage(data map[string]interface{}) (map[string]interface{}, error) {
+	// 检查 distinct_id
+	distinctIDI, ok := data["distinct_id"]
+	if !ok {
+		return data, invalid("distinct_id", "property [distinct_id] must not be empty")
+	}
+	distinctID, ok := distinctIDI.(string)
+	if !ok || len(distinctID) == 0 {
+		return data, invalid("distinct_id", "property [distinct_id] must not be empty")
+	}
+	if len(distinctID) > 255 {
+		return data, invalid("distinct_id", "the max length of [distinct_id] is 255")
+	}
+	// 检查 time
+	tsI, ok := data["time"]
+	if !ok {
+		return data, invalid("time", "property [time] must not be empty")
+	}
+	ts, ok := toInt64(tsI)
+	if !ok {
+		return data, invalid("time", "property [time] must be int64")
+	}
+	tsNum := len(strconv.FormatInt(ts, 10))
+	if tsNum < 10 || tsNum > 13 {
+		return data, invalid("time", "property [time] must be a timestamp in microseconds")
+	}
+	if tsNum == 10 {
+		ts *= 1000
+	}
+	data["time"] = ts
+
+	// 检查 event name
+	eventI, ok := data["event"]
+	if ok {
+		event, ok := eventI.(string)
+		if !ok {
+			return data, invalid("event", "property [event] must no be empty")
+		}
+		if !isValidName(event) {
+			return data, invalid("event", "event name must be a valid variable name. [event=%s]", event)
+		}
+	}
+	// 检查 project name
+	projectI, ok := data["project"]
+	if ok {
+		project, ok := projectI.(string)
+		if !ok {
+			return data, invalid("project", "property [project] must no be empty")
+		}
+		if !isValidName(project) {
+			return data, invalid("project", "project name must be a valid variable name. [project=%s]", project)
+		}
+	}
+	// 检查 properties
+	propertiesi, ok := data["properties"]
+	if ok {
+		properties, ok := propertiesi.(map[string]interface{})
+		if !ok {
+			return data, invalid("properties", "properties must be a map[string]interface{}")
+		}
+		for key, value := range properties {
+			field := "properties." + key
+			if len(key) > 255 {
+				return data, invalid(field, "the max length of property key is 256. [key=%s]", key)
+			}
+			if !isValidName(key) {
+				return data, invalid(field, "the property key must be a valid variable name. [key=%s]", key)
+			}
+			switch v := value.(type) {
+			case string:
+				if len(v) > 8192 {
+					return data, invalid(field, "the max length of property value is 8192. [value=%s]", value)
+				}
+			case int, int32, int64, float32, float64, json.Number, []string, bool:
+				continue
+			case []interface{}:
+				// 从 JSON 解码得到的字符串列表
+				for _, item := range v {
+					if _, ok := item.(string); !ok {
+						return data, invalid(field, "default: property value must be a str/int/float/list. [key=%s, value=%s]", key, reflect.TypeOf(value))
+					}
+				}
+			default:
+				return data, invalid(field, "default: property value must be a str/int/float/list. [key=%s, value=%s]", key, reflect.TypeOf(value))
+			}
+		}
+	}
+	return data, nil
+}
+
+// toInt64 将 int64 或从 JSON 解码得到的整数转换为 int64
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case json.Number:
+		i, err := v.Int64()
+		return i, err == nil
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > 1<<53 {
+			return 0, false
+		}
+		return int64(v), true
+	}
+	return 0, false
+}
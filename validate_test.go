@@ -0,0 +1,163 @@
+package sensorsanalytics_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	sa "gopkg.in/CuriosityChina/sa-sdk-go.v1"
+	"gopkg.in/CuriosityChina/sa-sdk-go.v1/satest"
+)
+
+// validMsg 返回一条合法的 track 数据，modify 修改后作为测试数据
+func validMsg(modify func(msg map[string]interface{})) map[string]interface{} {
+	msg := map[string]interface{}{
+		"type":        "track",
+		"event":       "OrderPaid",
+		"distinct_id": "u1",
+		"time":        int64(1704164645000),
+		"project":     "default",
+		"properties":  map[string]interface{}{"amount": 10},
+	}
+	if modify != nil {
+		modify(msg)
+	}
+	return msg
+}
+
+func setProperty(key string, value interface{}) func(msg map[string]interface{}) {
+	return func(msg map[string]interface{}) {
+		msg["properties"].(map[string]interface{})[key] = value
+	}
+}
+
+type validationCase struct {
+	name   string
+	modify func(msg map[string]interface{})
+	// wantField 为空时数据合法
+	wantField string
+	// wantMessage 与拆分为 ValidationError 之前 normalizeData 返回的错误信息一致
+	wantMessage string
+}
+
+func TestValidateMessage(t *testing.T) {
+	long := func(n int) string { return strings.Repeat("a", n) }
+	tests := []validationCase{
+		{"valid", nil, "", ""},
+		{"time in seconds", func(m map[string]interface{}) { m["time"] = int64(1704164645) }, "", ""},
+		{"time from JSON", func(m map[string]interface{}) { m["time"] = float64(1704164645000) }, "", ""},
+		{"time as json.Number", func(m map[string]interface{}) { m["time"] = json.Number("1704164645000") }, "", ""},
+		{"no event", func(m map[string]interface{}) { delete(m, "event") }, "", ""},
+		{"distinct_id of 255 bytes", func(m map[string]interface{}) { m["distinct_id"] = long(255) }, "", ""},
+		{"property key of 100 bytes", setProperty(long(100), 1), "", ""},
+		{"property value of 8192 bytes", setProperty("note", long(8192)), "", ""},
+		{"preset property", setProperty("$lib", "golang"), "", ""},
+		{"string list", setProperty("tags", []string{"a", "b"}), "", ""},
+		{"string list from JSON", setProperty("tags", []interface{}{"a", "b"}), "", ""},
+		{"bool", setProperty("vip", true), "", ""},
+
+		{"missing distinct_id", func(m map[string]interface{}) { delete(m, "distinct_id") },
+			"distinct_id", "property [distinct_id] must not be empty"},
+		{"empty distinct_id", func(m map[string]interface{}) { m["distinct_id"] = "" },
+			"distinct_id", "property [distinct_id] must not be empty"},
+		{"distinct_id not a string", func(m map[string]interface{}) { m["distinct_id"] = 1 },
+			"distinct_id", "property [distinct_id] must not be empty"},
+		{"distinct_id of 256 bytes", func(m map[string]interface{}) { m["distinct_id"] = long(256) },
+			"distinct_id", "the max length of [distinct_id] is 255"},
+		{"missing time", func(m map[string]interface{}) { delete(m, "time") },
+			"time", "property [time] must not be empty"},
+		{"time not an integer", func(m map[string]interface{}) { m["time"] = "1704164645000" },
+			"time", "property [time] must be int64"},
+		{"time with fraction", func(m map[string]interface{}) { m["time"] = 1704164645000.5 },
+			"time", "property [time] must be int64"},
+		{"time too short", func(m map[string]interface{}) { m["time"] = int64(170416464) },
+			"time", "property [time] must be a timestamp in microseconds"},
+		{"time too long", func(m map[string]interface{}) { m["time"] = int64(17041646450000) },
+			"time", "property [time] must be a timestamp in microseconds"},
+		{"event not a string", func(m map[string]interface{}) { m["event"] = 1 },
+			"event", "property [event] must no be empty"},
+		{"invalid event name", func(m map[string]interface{}) { m["event"] = "Order Paid" },
+			"event", "event name must be a valid variable name. [event=Order Paid]"},
+		{"reserved event name", func(m map[string]interface{}) { m["event"] = "events" },
+			"event", "event name must be a valid variable name. [event=events]"},
+		{"project not a string", func(m map[string]interface{}) { m["project"] = nil },
+			"project", "property [project] must no be empty"},
+		{"invalid project name", func(m map[string]interface{}) { m["project"] = "1st" },
+			"project", "project name must be a valid variable name. [project=1st]"},
+		{"properties not a map", func(m map[string]interface{}) { m["properties"] = []string{"a"} },
+			"properties", "properties must be a map[string]interface{}"},
+		{"property key of 256 bytes", setProperty(long(256), 1),
+			"properties." + long(256), "the max length of property key is 256. [key=" + long(256) + "]"},
+		{"property key of 101 bytes", setProperty(long(101), 1),
+			"properties." + long(101), "the property key must be a valid variable name. [key=" + long(101) + "]"},
+		{"invalid property key", setProperty("order-id", 1),
+			"properties.order-id", "the property key must be a valid variable name. [key=order-id]"},
+		{"property value of 8193 bytes", setProperty("note", long(8193)),
+			"properties.note", "the max length of property value is 8192. [value=" + long(8193) + "]"},
+		{"map property value", setProperty("address", map[string]interface{}{}),
+			"properties.address", "default: property value must be a str/int/float/list. [key=address, value=map[string]interface {}]"},
+		{"mixed list from JSON", setProperty("tags", []interface{}{"a", 1.0}),
+			"properties.tags", "default: property value must be a str/int/float/list. [key=tags, value=[]interface {}]"},
+	}
+	for _, keyword := range sa.FieldKeywords {
+		tests = append(tests, validationCase{"reserved property " + keyword, setProperty(keyword, 1),
+			"properties." + keyword, "the property key must be a valid variable name. [key=" + keyword + "]"})
+	}
+	for _, tt := range tests {
+		msg := validMsg(tt.modify)
+		err := sa.ValidateMessage(msg)
+		if tt.wantField == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", tt.name, err)
+			}
+			continue
+		}
+		var validationErr *sa.ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("%s: got %v, want *ValidationError", tt.name, err)
+			continue
+		}
+		if validationErr.Field != tt.wantField || validationErr.Message != tt.wantMessage {
+			t.Errorf("%s: got field %q message %q, want %q %q", tt.name, validationErr.Field, validationErr.Message, tt.wantField, tt.wantMessage)
+		}
+		if !errors.Is(err, sa.ErrIllegalDataException) || err.Error() != sa.ErrIllegalDataException.Error()+": "+tt.wantMessage {
+			t.Errorf("%s: Error() = %q", tt.name, err)
+		}
+	}
+}
+
+func TestValidateMessageDoesNotModify(t *testing.T) {
+	msg := validMsg(func(m map[string]interface{}) { m["time"] = int64(1704164645) })
+	if err := sa.ValidateMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["time"] != int64(1704164645) {
+		t.Errorf("ValidateMessage changed time to %v", msg["time"])
+	}
+}
+
+func TestNormalizeMessage(t *testing.T) {
+	msg := validMsg(func(m map[string]interface{}) { m["time"] = json.Number("1704164645") })
+	normalized, err := sa.NormalizeMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if normalized["time"] != int64(1704164645000) || msg["time"] != json.Number("1704164645") {
+		t.Errorf("normalized time %v, original %v", normalized["time"], msg["time"])
+	}
+}
+
+func TestClientRejectsInvalidProperties(t *testing.T) {
+	rec := satest.NewRecordingConsumer()
+	clt, _ := sa.NewClient(rec, "default", false)
+	err := clt.Track("u1", "OrderPaid", map[string]interface{}{"user_id": 1}, false)
+	var validationErr *sa.ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Field != "properties.user_id" {
+		t.Errorf("Track with reserved property: %v", err)
+	}
+	if err := clt.Track("u1", "Order Paid", nil, false); !errors.Is(err, sa.ErrIllegalDataException) {
+		t.Errorf("Track with invalid event name: %v", err)
+	}
+	satest.AssertNoEvents(t, rec)
+}